@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// アーカイブに入れるファイル
+const (
+	archiveJSON     = "episodes.json"
+	archiveCSV      = "episodes.csv"
+	archiveFeed     = "feed.xml"
+	archiveManifest = "manifest.json"
+)
+
+// アーカイブの中身の一覧。manifest.jsonとして最後に入れる
+type ArchiveManifest struct {
+	CreatedAt time.Time     `json:"created_at"`
+	Episodes  int           `json:"episodes"`
+	Files     []ArchiveFile `json:"files"`
+}
+
+type ArchiveFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link,omitempty"`
+	GUID        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// 公開日をRSSの日付にする。精度が年や月までのものはその初日とする
+func rssDate(releaseDate string) string {
+	for _, layout := range []string{time.DateOnly, "2006-01", "2006"} {
+		t, err := time.Parse(layout, releaseDate)
+		if err == nil {
+			return t.Format(time.RFC1123Z)
+		}
+	}
+	return ""
+}
+
+// エピソードをRSS 2.0のフィードとして書く。音声はプレビューのURLしか分からない
+func writeFeed(w io.Writer, title string, items []Item) error {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        "https://open.spotify.com/",
+			Description: title,
+		},
+	}
+	for _, item := range items {
+		entry := rssItem{
+			Title:       item.Name,
+			Link:        item.SpotifyURL(),
+			GUID:        item.ID,
+			PubDate:     rssDate(item.ReleaseDate),
+			Description: item.Description,
+		}
+		if item.AudioPreviewURL != "" {
+			entry.Enclosure = &rssEnclosure{URL: item.AudioPreviewURL, Type: "audio/mpeg"}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, entry)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "    ")
+	err = encoder.Encode(feed)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// JSON、CSV、RSSフィードとmanifest.jsonを1つの.tar.gzにまとめる。
+// titleはフィードのタイトル
+func ExportArchive(items []Item, path, title string, options ExportOptions) error {
+	if options.Append {
+		return fmt.Errorf("archives cannot be appended to")
+	}
+	err := options.validate(ExportJSON)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	manifest := ArchiveManifest{CreatedAt: now.UTC(), Episodes: len(items)}
+
+	add := func(name string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	members := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{archiveJSON, func(w io.Writer) error { return writeExport(w, items, ExportJSON, options, nil) }},
+		{archiveCSV, func(w io.Writer) error { return writeExport(w, items, ExportCSV, options, nil) }},
+		{archiveFeed, func(w io.Writer) error { return writeFeed(w, title, items) }},
+	}
+	for _, member := range members {
+		var buf bytes.Buffer
+		err = member.write(&buf)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", member.name, err)
+		}
+		err = add(member.name, buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to add %s to %s: %w", member.name, path, err)
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		manifest.Files = append(manifest.Files, ArchiveFile{
+			Name:   member.name,
+			Size:   buf.Len(),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = add(archiveManifest, append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w", archiveManifest, path, err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	err = gz.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// アーカイブのファイル名 -> 中身。入っていた順も返す
+func readArchive(t *testing.T, path string) ([]string, map[string][]byte) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	var names []string
+	members := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		members[header.Name] = data
+	}
+	return names, members
+}
+
+func TestExportArchive(t *testing.T) {
+	items := exportTestItems()
+	items[0].AudioPreviewURL = "https://p.scdn.co/mp3-preview/ep1"
+	items[1].ReleaseDate = "2023"
+	path := filepath.Join(t.TempDir(), "backup.tar.gz")
+
+	err := ExportArchive(items, path, "Show A", ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, members := readArchive(t, path)
+	if want := []string{"episodes.json", "episodes.csv", "feed.xml", "manifest.json"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("archive has %v, want %v", names, want)
+	}
+
+	var imported []Item
+	err = json.Unmarshal(members["episodes.json"], &imported)
+	if err != nil {
+		t.Errorf("invalid episodes.json: %v", err)
+	}
+	if !reflect.DeepEqual(imported, items) {
+		t.Errorf("episodes.json has %+v, want %+v", imported, items)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(string(members["episodes.csv"]))).ReadAll()
+	if err != nil {
+		t.Errorf("invalid episodes.csv: %v", err)
+	}
+	if len(records) != len(items)+1 {
+		t.Errorf("episodes.csv has %d rows, want a header and %d episodes", len(records), len(items))
+	}
+
+	var feed rssFeed
+	err = xml.Unmarshal(members["feed.xml"], &feed)
+	if err != nil {
+		t.Errorf("invalid feed.xml: %v", err)
+	}
+	if feed.Version != "2.0" || feed.Channel.Title != "Show A" || len(feed.Channel.Items) != len(items) {
+		t.Errorf("feed = %+v, want an RSS 2.0 channel with %d items", feed, len(items))
+	} else {
+		first, second := feed.Channel.Items[0], feed.Channel.Items[1]
+		if first.GUID != "ep1" || first.PubDate != "Tue, 02 Jan 2024 00:00:00 +0000" || first.Enclosure == nil || first.Enclosure.URL != items[0].AudioPreviewURL {
+			t.Errorf("first feed item = %+v", first)
+		}
+		if second.PubDate != "Sun, 01 Jan 2023 00:00:00 +0000" || second.Enclosure != nil {
+			t.Errorf("second feed item = %+v, want the first day of the year and no enclosure", second)
+		}
+	}
+
+	var manifest ArchiveManifest
+	err = json.Unmarshal(members["manifest.json"], &manifest)
+	if err != nil {
+		t.Fatalf("invalid manifest.json: %v", err)
+	}
+	if manifest.Episodes != len(items) || len(manifest.Files) != 3 {
+		t.Errorf("manifest = %+v, want %d episodes and 3 files", manifest, len(items))
+	}
+	for _, file := range manifest.Files {
+		sum := sha256.Sum256(members[file.Name])
+		if file.Size != len(members[file.Name]) || file.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("manifest entry %+v does not match %s", file, file.Name)
+		}
+	}
+}
+
+func TestFetchArchive(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	path := filepath.Join(t.TempDir(), "backup.tar.gz")
+
+	code := runCommand(t, config, "fetch", "-show", testShowA, "-archive", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	_, members := readArchive(t, path)
+	var feed rssFeed
+	err := xml.Unmarshal(members["feed.xml"], &feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Channel.Title != "Show 1111" || len(feed.Channel.Items) != 3 {
+		t.Errorf("feed has title %q and %d items, want the show name and 3", feed.Channel.Title, len(feed.Channel.Items))
+	}
+	if err := VerifyChecksum(path); err != nil {
+		t.Error(err)
+	}
+}
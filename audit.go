@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// 権限の確認結果
+type AuditStatus int
+
+const (
+	// 認可のあとでしか返らない結果が得られた
+	AuditOK AuditStatus = iota
+	// 権限エラーが返った
+	AuditMissing
+	// 認可まで届いたか分からないエラー(通信エラー、検証エラーなど)
+	AuditUnknown
+)
+
+type AuditResult struct {
+	Permission string
+	Status     AuditStatus
+	Err        error
+}
+
+// 権限不足を表すエラーか
+func isAccessDenied(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	switch aerr.Code() {
+	case "AccessDeniedException", "UnrecognizedClientException", "MissingAuthenticationTokenException":
+		return true
+	default:
+		return false
+	}
+}
+
+// 認可を通ったあとでしか返らないエラーか。検証エラーなどは認可より前に返ることがあるので含めない
+func isAuthorizedError(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	switch aerr.Code() {
+	case dynamodb.ErrCodeConditionalCheckFailedException, dynamodb.ErrCodeResourceNotFoundException, dynamodb.ErrCodeResourceInUseException:
+		return true
+	default:
+		return false
+	}
+}
+
+func auditResult(permission string, err error) AuditResult {
+	switch {
+	case err == nil || isAuthorizedError(err):
+		return AuditResult{Permission: permission, Status: AuditOK}
+	case isAccessDenied(err):
+		return AuditResult{Permission: permission, Status: AuditMissing, Err: err}
+	default:
+		return AuditResult{Permission: permission, Status: AuditUnknown, Err: err}
+	}
+}
+
+// データを変更しないリクエストで必要な権限を確認する。
+// 書き込み系は認可のあとで必ず失敗する条件・入力で呼ぶ
+func AuditDynamoDB(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string) []AuditResult {
+	var results []AuditResult
+
+	_, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	results = append(results, auditResult("dynamodb:DescribeTable", err))
+	tableExists := err == nil
+
+	_, err = svc.ScanWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+		Limit:     aws.Int64(1),
+	})
+	results = append(results, auditResult("dynamodb:Scan", err))
+
+	// 成り立たない条件なので書き込まれない
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"ID": {S: aws.String("audit-probe")},
+		},
+		ConditionExpression: aws.String("attribute_exists(ID) AND attribute_not_exists(ID)"),
+	})
+	results = append(results, auditResult("dynamodb:PutItem", err))
+
+	// 存在しないエピソードの削除なので何も消えない
+	_, err = svc.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]*dynamodb.WriteRequest{
+			tableName: {
+				{DeleteRequest: &dynamodb.DeleteRequest{
+					Key: map[string]*dynamodb.AttributeValue{
+						"ID": {S: aws.String("audit-probe")},
+					},
+				}},
+			},
+		},
+	})
+	results = append(results, auditResult("dynamodb:BatchWriteItem", err))
+
+	// 既にあるテーブルを同じ定義で作ろうとする。テーブルが無いと本当に作成されるので確認しない
+	if tableExists {
+		_, err = svc.CreateTableWithContext(ctx, episodeTableInput(tableName))
+		results = append(results, auditResult("dynamodb:CreateTable", err))
+	} else {
+		results = append(results, AuditResult{
+			Permission: "dynamodb:CreateTable",
+			Status:     AuditUnknown,
+			Err:        fmt.Errorf("table %s does not exist, so it cannot be checked without creating it", tableName),
+		})
+	}
+
+	// 存在しないテーブルを指定する
+	_, err = svc.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{
+		TableName: aws.String(tableName + "-audit-probe"),
+	})
+	results = append(results, auditResult("dynamodb:DeleteTable", err))
+
+	return results
+}
+
+func AuditSpotify(ctx context.Context, client *http.Client, config Config) AuditResult {
+	_, err := GetAccessToken(ctx, client, config)
+	if err != nil {
+		return AuditResult{Permission: "spotify:client_credentials", Status: AuditMissing, Err: err}
+	}
+
+	return AuditResult{Permission: "spotify:client_credentials", Status: AuditOK}
+}
+
+// 不足しているか確認できなかった権限があればfalseを返す
+func PrintAudit(w io.Writer, results []AuditResult) bool {
+	ok := true
+	for _, result := range results {
+		switch result.Status {
+		case AuditOK:
+			fmt.Fprintf(w, "OK       %s\n", result.Permission)
+		case AuditMissing:
+			ok = false
+			fmt.Fprintf(w, "MISSING  %s: %v\n", result.Permission, result.Err)
+		default:
+			ok = false
+			fmt.Fprintf(w, "UNKNOWN  %s: %v\n", result.Permission, result.Err)
+		}
+	}
+
+	return ok
+}
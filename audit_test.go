@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// 確認で呼ばれる操作だけを持つDynamoDB。テーブルは空で、failがエラーを返せばそれを返す
+type auditDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	tables map[string]bool
+	fail   func(op string) error
+}
+
+func (d *auditDynamoDB) check(op string, tableName *string) error {
+	if d.fail != nil {
+		if err := d.fail(op); err != nil {
+			return err
+		}
+	}
+	if !d.tables[aws.StringValue(tableName)] {
+		return awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil)
+	}
+	return nil
+}
+
+func (d *auditDynamoDB) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{}, d.check("DescribeTable", input.TableName)
+}
+
+func (d *auditDynamoDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{}, d.check("Scan", input.TableName)
+}
+
+func (d *auditDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	err := d.check("PutItem", input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "the conditional request failed", nil)
+}
+
+func (d *auditDynamoDB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	for tableName := range input.RequestItems {
+		err := d.check("BatchWriteItem", aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (d *auditDynamoDB) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	err := d.check("CreateTable", input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, "table already exists", nil)
+}
+
+func (d *auditDynamoDB) DeleteTableWithContext(ctx aws.Context, input *dynamodb.DeleteTableInput, opts ...request.Option) (*dynamodb.DeleteTableOutput, error) {
+	return nil, d.check("DeleteTable", input.TableName)
+}
+
+func auditStatuses(results []AuditResult) map[string]AuditStatus {
+	statuses := make(map[string]AuditStatus)
+	for _, result := range results {
+		statuses[result.Permission] = result.Status
+	}
+	return statuses
+}
+
+func TestAuditDynamoDBPutItemDenied(t *testing.T) {
+	svc := &auditDynamoDB{
+		tables: map[string]bool{"Program": true},
+		fail: func(op string) error {
+			if op == "PutItem" {
+				return awserr.New("AccessDeniedException", "not authorized to perform dynamodb:PutItem", nil)
+			}
+			return nil
+		},
+	}
+
+	results := AuditDynamoDB(context.Background(), svc, "Program")
+
+	for permission, status := range auditStatuses(results) {
+		want := AuditOK
+		if permission == "dynamodb:PutItem" {
+			want = AuditMissing
+		}
+		if status != want {
+			t.Errorf("%s = %v, want %v", permission, status, want)
+		}
+	}
+
+	var out bytes.Buffer
+	if PrintAudit(&out, results) {
+		t.Error("PrintAudit() = true, want false")
+	}
+	if !strings.Contains(out.String(), "MISSING  dynamodb:PutItem") {
+		t.Errorf("output does not report the missing permission:\n%s", out.String())
+	}
+}
+
+func TestAuditDynamoDBUnreachable(t *testing.T) {
+	svc := &auditDynamoDB{
+		fail: func(op string) error {
+			return awserr.New("RequestError", "send request failed", nil)
+		},
+	}
+
+	for permission, status := range auditStatuses(AuditDynamoDB(context.Background(), svc, "Program")) {
+		if status != AuditUnknown {
+			t.Errorf("%s = %v, want AuditUnknown", permission, status)
+		}
+	}
+}
+
+func TestAuditDynamoDBMissingTable(t *testing.T) {
+	svc := &auditDynamoDB{}
+
+	statuses := auditStatuses(AuditDynamoDB(context.Background(), svc, "Program"))
+
+	if statuses["dynamodb:CreateTable"] != AuditUnknown {
+		t.Errorf("dynamodb:CreateTable = %v, want AuditUnknown", statuses["dynamodb:CreateTable"])
+	}
+	if statuses["dynamodb:Scan"] != AuditOK {
+		t.Errorf("dynamodb:Scan = %v, want AuditOK", statuses["dynamodb:Scan"])
+	}
+}
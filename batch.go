@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// BatchWriteItemの1リクエストあたりの上限
+const batchWriteLimit = 25
+
+// 書き込む属性。エピソードの属性に追加属性と更新日時を加える
+func writeAttributes(config Config, item Item, lastUpdated string) map[string]*dynamodb.AttributeValue {
+	attributes := itemToAttributes(config, item)
+	compressDescription(attributes, config.CompressDescriptionsOver)
+	for key, value := range config.ExtraAttributes {
+		attributes[key] = &dynamodb.AttributeValue{
+			S: aws.String(value),
+		}
+	}
+	attributes["LastUpdated"] = &dynamodb.AttributeValue{
+		S: aws.String(lastUpdated),
+	}
+
+	return attributes
+}
+
+// エピソードのLastUpdated。取得時刻が無ければ(インポートなど)nowを使う。
+// 固定長のUTC表記なので文字列比較で新旧を判定できる
+func lastUpdated(item Item, now time.Time) string {
+	if !item.FetchedAt.IsZero() {
+		now = item.FetchedAt
+	}
+	return now.UTC().Format(time.RFC3339)
+}
+
+// BatchWriteItemで25件ずつ書き込み、書き込みが確認できた件数を返す。
+// 言語別のテーブルに分ける場合は、テーブルごとの書き込みをそれぞれ1件と数える。
+// write_progress_fileがあれば前回の実行で確認済みのエピソードは送らない
+func batchWriteItems(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, items []Item, options WriteOptions) (int, error) {
+	now := time.Now()
+
+	progress := WriteProgress{}
+	if config.WriteProgressFile != "" {
+		var err error
+		progress, err = LoadWriteProgress(config.WriteProgressFile)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load write progress file: %w", err)
+		}
+	}
+	confirmed := progress.confirmed()
+	var resumed int
+
+	// テーブルごとにまとめる。1回のリクエストに同じキーがあるとエラーになるので後のものを残す
+	var tables []string
+	requests := make(map[string][]*dynamodb.WriteRequest)
+	// requestsと同じ順のエピソードID。属性名は対応表で変わりうるのでエピソードから取る
+	ids := make(map[string][]string)
+	positions := make(map[string]map[string]int)
+	var total int
+	for _, item := range items {
+		attributes := writeAttributes(config, item, lastUpdated(item, now))
+		for _, tableName := range tablesForItem(config, item) {
+			if confirmed[tableName][item.ID] {
+				resumed++
+				continue
+			}
+			if _, ok := positions[tableName]; !ok {
+				tables = append(tables, tableName)
+				positions[tableName] = make(map[string]int)
+			}
+
+			request := &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: attributes},
+			}
+			if i, ok := positions[tableName][item.ID]; ok {
+				requests[tableName][i] = request
+				continue
+			}
+			positions[tableName][item.ID] = len(requests[tableName])
+			requests[tableName] = append(requests[tableName], request)
+			ids[tableName] = append(ids[tableName], item.ID)
+			total++
+		}
+	}
+
+	if resumed > 0 {
+		slog.Info("skipping episodes written before the interruption", "count", resumed, "file", config.WriteProgressFile)
+	}
+
+	var done, written int
+	for _, tableName := range tables {
+		err := EnsureTable(ctx, svc, tableName)
+		if err != nil {
+			return written, fmt.Errorf("failed to create table %s: %w", tableName, err)
+		}
+
+		for start := 0; start < len(requests[tableName]); start += batchWriteLimit {
+			batch := requests[tableName][start:min(start+batchWriteLimit, len(requests[tableName]))]
+
+			n, err := writeBatch(ctx, svc, config, tableName, batch, options.DeadLetters)
+			written += n
+			if err != nil {
+				return written, err
+			}
+
+			// 再送しきれずdead letterに残したものも、このバッチは処理済みとする
+			if config.WriteProgressFile != "" {
+				progress[tableName] = append(progress[tableName], ids[tableName][start:start+len(batch)]...)
+				err = SaveWriteProgress(config.WriteProgressFile, progress)
+				if err != nil {
+					return written, fmt.Errorf("failed to save write progress file: %w", err)
+				}
+			}
+
+			done += len(batch)
+			sendProgress(options.Progress, Progress{
+				Stage: ProgressWrite,
+				Done:  done,
+				Total: total,
+			})
+		}
+	}
+
+	if config.WriteProgressFile != "" {
+		err := ClearCheckpoints(config.WriteProgressFile)
+		if err != nil {
+			return written, fmt.Errorf("failed to remove write progress file: %w", err)
+		}
+	}
+
+	return written, nil
+}
+
+// UnprocessedItemsが無くなるまでバックオフしながら再送する。
+// 書き込みが確認できた件数を返す。deadLettersがあれば再送しきれなかったものを記録する
+func writeBatch(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, tableName string, batch []*dynamodb.WriteRequest, deadLetters *DeadLetterWriter) (int, error) {
+	pending := map[string][]*dynamodb.WriteRequest{tableName: batch}
+
+	for attempt := 0; ; attempt++ {
+		written := len(batch) - len(pending[tableName])
+
+		output, err := batchWriteItem(ctx, svc, tableName, pending, attempt)
+		if err != nil {
+			return written, err
+		}
+
+		written = len(batch) - len(output.UnprocessedItems[tableName])
+		if len(output.UnprocessedItems) == 0 {
+			return written, nil
+		}
+		if attempt >= config.maxRetries() {
+			err := fmt.Errorf("%d items left unprocessed in %s after %d retries", len(output.UnprocessedItems[tableName]), tableName, attempt)
+			if deadLetters != nil {
+				slog.Error("recording unprocessed items as dead letters", "table", tableName, "count", len(output.UnprocessedItems[tableName]), "error", err)
+				deadLetters.RecordWriteRequests(output.UnprocessedItems[tableName], err)
+				return written, nil
+			}
+			return written, err
+		}
+
+		select {
+		case <-time.After(config.retryBackoff(attempt)):
+		case <-ctx.Done():
+			return written, ctx.Err()
+		}
+		pending = output.UnprocessedItems
+	}
+}
+
+// BatchWriteItemの1回の呼び出し。再送も含めて1回ごとにspanを作る
+func batchWriteItem(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string, pending map[string][]*dynamodb.WriteRequest, attempt int) (*dynamodb.BatchWriteItemOutput, error) {
+	ctx, span := tracer.Start(ctx, "write batch")
+	span.SetAttributes(
+		attribute.String("table", tableName),
+		attribute.Int("items", len(pending[tableName])),
+		attribute.Int("attempt", attempt),
+	)
+	defer span.End()
+
+	output, err := svc.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: pending,
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("unprocessed", len(output.UnprocessedItems[tableName])))
+
+	return output, nil
+}
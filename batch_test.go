@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func testItems(n int) []Item {
+	var items []Item
+	for i := 1; i <= n; i++ {
+		items = append(items, Item{ID: fmt.Sprintf("ep%03d", i), Name: fmt.Sprintf("Episode %d", i), ShowID: testShowA})
+	}
+	return items
+}
+
+func TestBatchWriteItemsSpans(t *testing.T) {
+	spans := recordSpans(t)
+	dynamo := newFakeDynamoDB()
+	config := Config{MaxRetries: 3, RetryBaseDelayMs: 1}
+
+	written, err := batchWriteItems(context.Background(), dynamo, config, testItems(60), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 60 {
+		t.Errorf("written = %d, want 60", written)
+	}
+
+	batches := spansNamed(spans, "write batch")
+	if len(batches) != 3 {
+		t.Fatalf("%d write batch spans, want 3", len(batches))
+	}
+	for i, want := range []int64{25, 25, 10} {
+		if got := spanInt(batches[i], "items"); got != want {
+			t.Errorf("batch %d has %d items, want %d", i, got, want)
+		}
+	}
+}
+
+func TestBatchWriteItemsRetriesUnprocessed(t *testing.T) {
+	spans := recordSpans(t)
+	dynamo := newFakeDynamoDB()
+	// 最初のリクエストだけ先頭の2件を未処理として返す
+	first := true
+	dynamo.unprocessed = func(tableName string, requests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+		if !first {
+			return nil
+		}
+		first = false
+		return requests[:2]
+	}
+	config := Config{MaxRetries: 3, RetryBaseDelayMs: 1}
+
+	written, err := batchWriteItems(context.Background(), dynamo, config, testItems(60), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 60 {
+		t.Errorf("written = %d, want 60", written)
+	}
+	if n := len(dynamo.items("Program")); n != 60 {
+		t.Errorf("stored %d items, want 60", n)
+	}
+	if n := dynamo.countCalls("BatchWriteItem"); n != 4 {
+		t.Errorf("BatchWriteItem called %d times, want 4", n)
+	}
+
+	batches := spansNamed(spans, "write batch")
+	if len(batches) != 4 {
+		t.Fatalf("%d write batch spans, want 4", len(batches))
+	}
+	if attempt, items := spanInt(batches[1], "attempt"), spanInt(batches[1], "items"); attempt != 1 || items != 2 {
+		t.Errorf("retry span has attempt=%d items=%d, want attempt=1 items=2", attempt, items)
+	}
+}
+
+func TestFetchWritesExtraAttributes(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 2)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.ExtraAttributes = map[string]string{"environment": "prod", "source": "spotify"}
+
+	code := runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	items := dynamo.items(config.tableName())
+	if len(items) != 2 {
+		t.Fatalf("stored %d episodes, want 2", len(items))
+	}
+	for _, item := range items {
+		for key, want := range config.ExtraAttributes {
+			if got := aws.StringValue(item[key].S); got != want {
+				t.Errorf("%s = %q, want %q", key, got, want)
+			}
+		}
+	}
+
+	// エピソードの属性と重なる名前は設定エラーにする
+	dynamo = newFakeDynamoDB()
+	config.Endpoint = dynamo.serve(t)
+	config.ExtraAttributes = map[string]string{"Name": "overwritten"}
+	code = runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 1 {
+		t.Errorf("exit code with a colliding extra attribute = %d, want 1", code)
+	}
+	if n := dynamo.countCalls("BatchWriteItem"); n != 0 {
+		t.Errorf("BatchWriteItem called %d times, want 0", n)
+	}
+}
+
+func TestPutItemCountsWrittenBeforeFailure(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("batch", func(t *testing.T) {
+		dynamo := newFakeDynamoDB()
+		// 3件目はいつまでも未処理で返す
+		dynamo.unprocessed = func(tableName string, requests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+			for _, request := range requests {
+				if aws.StringValue(request.PutRequest.Item["ID"].S) == "ep003" {
+					return []*dynamodb.WriteRequest{request}
+				}
+			}
+			return nil
+		}
+		config := testConfig(nil, dynamo.serve(t))
+
+		n, err := PutItem(ctx, config, testItems(3), nil)
+		if err == nil {
+			t.Error("unprocessed episode did not return an error")
+		}
+		if n != 2 {
+			t.Errorf("PutItem() = %d, want 2", n)
+		}
+	})
+
+	t.Run("one by one", func(t *testing.T) {
+		dynamo := newFakeDynamoDB()
+		dynamo.fail = func(op string, input any) error {
+			if put, ok := input.(*dynamodb.PutItemInput); ok && aws.StringValue(put.Item["ID"].S) == "ep003" {
+				return awserr.New("ValidationException", "item too large", nil)
+			}
+			return nil
+		}
+		config := testConfig(nil, dynamo.serve(t))
+		config.NoClobber = true
+
+		n, err := PutItem(ctx, config, testItems(4), nil)
+		if err == nil || !strings.Contains(err.Error(), "ep003") {
+			t.Errorf("err = %v, want the failure on ep003", err)
+		}
+		if n != 2 {
+			t.Errorf("PutItem() = %d, want 2", n)
+		}
+		if got := dynamo.ids(config.tableName()); !reflect.DeepEqual(got, []string{"ep001", "ep002"}) {
+			t.Errorf("stored IDs = %v, want the episodes before the failure", got)
+		}
+	})
+}
+
+func TestBatchWriteItemsResumesAfterCrash(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	// 送られたエピソードID
+	var sent []string
+	var crash bool
+	dynamo.fail = func(op string, input any) error {
+		batch, ok := input.(*dynamodb.BatchWriteItemInput)
+		if !ok {
+			return nil
+		}
+		// 4バッチのうち2バッチを書いたところで落ちる
+		if crash && len(sent) == 2*batchWriteLimit {
+			return awserr.New("InternalFailure", "process killed", nil)
+		}
+		for _, requests := range batch.RequestItems {
+			for _, request := range requests {
+				sent = append(sent, aws.StringValue(request.PutRequest.Item["ID"].S))
+			}
+		}
+		return nil
+	}
+	config := Config{MaxRetries: 1, RetryBaseDelayMs: 1}
+	config.WriteProgressFile = filepath.Join(t.TempDir(), "progress.json")
+	items := testItems(4 * batchWriteLimit)
+
+	crash = true
+	_, err := batchWriteItems(context.Background(), dynamo, config, items, WriteOptions{})
+	if err == nil {
+		t.Fatal("batchWriteItems() succeeded, want the crash")
+	}
+	progress, err := LoadWriteProgress(config.WriteProgressFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(progress["Program"]); n != 2*batchWriteLimit {
+		t.Fatalf("progress has %d episodes, want %d", n, 2*batchWriteLimit)
+	}
+
+	crash = false
+	sent = nil
+	written, err := batchWriteItems(context.Background(), dynamo, config, items, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 2*batchWriteLimit {
+		t.Errorf("resumed run wrote %d, want %d", written, 2*batchWriteLimit)
+	}
+	if want := itemIDs(items[2*batchWriteLimit:]); !reflect.DeepEqual(sent, want) {
+		t.Errorf("resumed run sent %v, want only %v", sent, want)
+	}
+	if n := len(dynamo.ids("Program")); n != len(items) {
+		t.Errorf("stored %d episodes, want %d", n, len(items))
+	}
+	// 書き終えたら次の実行は最初から
+	if _, err := os.Stat(config.WriteProgressFile); !os.IsNotExist(err) {
+		t.Errorf("write progress file remains after the run: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type BenchmarkResult struct {
+	Items    int
+	Duration time.Duration
+	// BatchWriteItem 1回ごとの所要時間(未処理分の再送を含む)
+	Latencies []time.Duration
+	// スロットリングで失敗したリクエストと、未処理で返されたエピソードの数
+	Throttled   int64
+	Unprocessed int64
+	// 最後の並列数。-max-concurrencyがあればスロットリングに応じて変わる
+	Concurrency int
+}
+
+func (r BenchmarkResult) WritesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Items) / r.Duration.Seconds()
+}
+
+// p (0〜100) パーセンタイルのレイテンシ
+func (r BenchmarkResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(r.Latencies)
+	slices.Sort(sorted)
+
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+func (r BenchmarkResult) Print(w io.Writer) {
+	fmt.Fprintf(w, "Episodes written:     %d\n", r.Items)
+	fmt.Fprintf(w, "Batches:              %d\n", len(r.Latencies))
+	fmt.Fprintf(w, "Duration:             %s\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "Writes per second:    %.1f\n", r.WritesPerSecond())
+	fmt.Fprintf(w, "Batch latency p50:    %s\n", r.Percentile(50).Round(time.Millisecond))
+	fmt.Fprintf(w, "Batch latency p90:    %s\n", r.Percentile(90).Round(time.Millisecond))
+	fmt.Fprintf(w, "Batch latency p99:    %s\n", r.Percentile(99).Round(time.Millisecond))
+	fmt.Fprintf(w, "Throttled requests:   %d\n", r.Throttled)
+	fmt.Fprintf(w, "Unprocessed episodes: %d\n", r.Unprocessed)
+	fmt.Fprintf(w, "Final concurrency:    %d\n", r.Concurrency)
+}
+
+// ベンチマーク用のエピソード。説明文は実際のエピソードに近い長さにする
+func syntheticItems(n int) []Item {
+	items := make([]Item, n)
+	for i := range items {
+		id := "benchmark" + strconv.Itoa(i)
+		items[i] = Item{
+			ID:          id,
+			Name:        "Benchmark episode " + strconv.Itoa(i),
+			Description: fmt.Sprintf("%0500d", i),
+			DurationMs:  int64(30*time.Minute/time.Millisecond) + int64(i),
+			ReleaseDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i%3650).Format(time.DateOnly),
+		}
+	}
+
+	return items
+}
+
+// バッチの書き込みがスロットリングされたかを記録する*atomic.Boolのcontextキー
+type batchThrottledKey struct{}
+
+// n件の合成エピソードをtableNameにconcurrencyの並列数のBatchWriteItemで書き込み、スループットを測る
+func RunBenchmark(ctx context.Context, svc *dynamodb.DynamoDB, config Config, tableName string, n int, concurrency *AdaptiveConcurrency) (BenchmarkResult, error) {
+	var throttled, unprocessed atomic.Int64
+	svc.Handlers.CompleteAttempt.PushBack(func(r *request.Request) {
+		// 未処理で返されたのもスロットリングとみなす
+		batchThrottled := request.IsErrorThrottle(r.Error)
+		if batchThrottled {
+			throttled.Add(1)
+		}
+		if output, ok := r.Data.(*dynamodb.BatchWriteItemOutput); ok && r.Error == nil {
+			for _, requests := range output.UnprocessedItems {
+				unprocessed.Add(int64(len(requests)))
+				batchThrottled = true
+			}
+		}
+		if flag, ok := r.Context().Value(batchThrottledKey{}).(*atomic.Bool); ok && batchThrottled {
+			flag.Store(true)
+		}
+	})
+
+	err := EnsureTable(ctx, svc, tableName)
+	if err != nil {
+		return BenchmarkResult{}, fmt.Errorf("failed to create table %s: %w", tableName, err)
+	}
+
+	lastUpdated := time.Now().UTC().Format(time.RFC3339)
+	var batches [][]*dynamodb.WriteRequest
+	var batch []*dynamodb.WriteRequest
+	for _, item := range syntheticItems(n) {
+		batch = append(batch, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: writeAttributes(config, item, lastUpdated)},
+		})
+		if len(batch) == batchWriteLimit {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	latencies := make([]time.Duration, len(batches))
+	errs := make([]error, len(batches))
+	jobs := make(chan int)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range concurrency.max {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				generation := concurrency.Acquire()
+				var batchThrottled atomic.Bool
+				batchStart := time.Now()
+				_, errs[i] = writeBatch(context.WithValue(ctx, batchThrottledKey{}, &batchThrottled), svc, config, tableName, batches[i], nil)
+				latencies[i] = time.Since(batchStart)
+				concurrency.Release(generation, batchThrottled.Load())
+			}
+		}()
+	}
+	for i := range batches {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := BenchmarkResult{
+		Items:       n,
+		Duration:    time.Since(start),
+		Latencies:   latencies,
+		Throttled:   throttled.Load(),
+		Unprocessed: unprocessed.Load(),
+		Concurrency: concurrency.Limit(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestBenchmarkResultMetrics(t *testing.T) {
+	result := BenchmarkResult{Items: 100, Duration: 2 * time.Second}
+	for i := 10; i >= 1; i-- {
+		result.Latencies = append(result.Latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	if got := result.WritesPerSecond(); got != 50 {
+		t.Errorf("WritesPerSecond() = %v, want 50", got)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{100, 10 * time.Millisecond},
+	} {
+		if got := result.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%v) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+	if got := (BenchmarkResult{}).WritesPerSecond(); got != 0 {
+		t.Errorf("WritesPerSecond() without a duration = %v, want 0", got)
+	}
+}
+
+func TestRunBenchmark(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	// 各バッチの先頭のエピソードを1回だけ未処理で返す
+	returned := make(map[string]bool)
+	dynamo.unprocessed = func(tableName string, requests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+		id := *requests[0].PutRequest.Item["ID"].S
+		if returned[id] {
+			return nil
+		}
+		returned[id] = true
+		return requests[:1]
+	}
+	config := testConfig(nil, dynamo.serve(t))
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := RunBenchmark(context.Background(), svc, config, "Bench", 60, NewAdaptiveConcurrency(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(dynamo.ids("Bench")); n != 60 {
+		t.Errorf("%d episodes written, want 60", n)
+	}
+	if result.Items != 60 || len(result.Latencies) != 3 {
+		t.Errorf("Items, batches = %d, %d, want 60, 3", result.Items, len(result.Latencies))
+	}
+	if result.Duration <= 0 || result.WritesPerSecond() <= 0 {
+		t.Errorf("Duration, WritesPerSecond = %s, %v, want positive", result.Duration, result.WritesPerSecond())
+	}
+	p50, p99 := result.Percentile(50), result.Percentile(99)
+	if p50 <= 0 || p99 < p50 || p99 > result.Duration {
+		t.Errorf("p50, p99 = %s, %s within a %s run", p50, p99, result.Duration)
+	}
+	if result.Unprocessed == 0 {
+		t.Error("Unprocessed = 0, want the returned episodes counted")
+	}
+}
+
+func TestRunBenchmarkAdaptiveConcurrency(t *testing.T) {
+	const threshold = 3
+	dynamo := newFakeDynamoDB()
+	// 同時にthresholdを超えるBatchWriteItemはすべて未処理で返す
+	var inflight atomic.Int64
+	dynamo.unprocessed = func(tableName string, requests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+		if inflight.Load() > threshold {
+			return requests
+		}
+		return nil
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.Header.Get("X-Amz-Target"), ".BatchWriteItem") {
+			inflight.Add(1)
+			defer inflight.Add(-1)
+			time.Sleep(2 * time.Millisecond)
+		}
+		dynamo.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	config := testConfig(nil, server.URL)
+	config.MaxRetries = 20
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concurrency := NewAdaptiveConcurrency(1, 8)
+	concurrency.probeInterval = 1 << 30
+
+	result, err := RunBenchmark(context.Background(), svc, config, "Bench", 60*batchWriteLimit, concurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(dynamo.ids("Bench")); n != 60*batchWriteLimit {
+		t.Errorf("%d episodes written, want %d", n, 60*batchWriteLimit)
+	}
+	if result.Unprocessed == 0 {
+		t.Error("no write was throttled; the concurrency never reached the threshold")
+	}
+	if result.Concurrency < 1 || result.Concurrency > threshold {
+		t.Errorf("final concurrency = %d, want it to settle at or below %d", result.Concurrency, threshold)
+	}
+}
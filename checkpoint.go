@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// 今回のサイクルで書き込みを終えた番組ID -> その時点の総エピソード数。
+// すべての番組が終わったら削除し、次の実行は最初からやり直す
+type Checkpoints map[string]int
+
+func LoadCheckpoints(path string) (Checkpoints, error) {
+	checkpoints := Checkpoints{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &checkpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkpoints, nil
+}
+
+func SaveCheckpoints(path string, checkpoints Checkpoints) error {
+	data, err := json.MarshalIndent(checkpoints, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// 書き込み途中で中断されても壊れたファイルを残さない
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func ClearCheckpoints(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// 書き込みを確認したテーブル名 -> エピソードID。
+// batchWriteItemsが最後まで書き終えたら削除する
+type WriteProgress map[string][]string
+
+func LoadWriteProgress(path string) (WriteProgress, error) {
+	progress := WriteProgress{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &progress)
+	if err != nil {
+		return nil, err
+	}
+
+	return progress, nil
+}
+
+func SaveWriteProgress(path string, progress WriteProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// テーブルごとの確認済みのID
+func (p WriteProgress) confirmed() map[string]map[string]bool {
+	confirmed := make(map[string]map[string]bool)
+	for tableName, ids := range p {
+		confirmed[tableName] = make(map[string]bool)
+		for _, id := range ids {
+			confirmed[tableName][id] = true
+		}
+	}
+	return confirmed
+}
+
+// 書き込む番組。204では番組情報が空なので、要求した番組IDで区別する
+type syncedShow struct {
+	Program string
+	Info    ProgramInfo
+}
+
+// 番組ごとに書き込み、終わるたびにチェックポイントを保存する。
+// 部分的にしか取得できなかった番組は次回も取り直すので記録しない
+func putItemsByShow(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, items []Item, shows []syncedShow, partial map[string]bool, checkpoints Checkpoints, options WriteOptions) (WriteResult, error) {
+	byShow := make(map[string][]Item)
+	for _, item := range items {
+		byShow[item.ShowID] = append(byShow[item.ShowID], item)
+	}
+
+	var result WriteResult
+	for _, show := range shows {
+		if len(byShow[show.Program]) > 0 {
+			showResult, err := writeItems(ctx, svc, config, byShow[show.Program], options)
+			result.Written += showResult.Written
+			result.New = append(result.New, showResult.New...)
+			result.Skipped += showResult.Skipped
+			if err != nil {
+				return result, err
+			}
+		}
+
+		if partial[show.Program] {
+			continue
+		}
+		checkpoints[show.Program] = show.Info.TotalEpisodes
+		err := SaveCheckpoints(config.CheckpointFile, checkpoints)
+		if err != nil {
+			return result, fmt.Errorf("failed to save checkpoint file: %w", err)
+		}
+	}
+
+	return result, nil
+}
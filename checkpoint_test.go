@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestFetchResumesFromCheckpoint(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	for _, id := range []string{testShowA, testShowB, testShowC} {
+		spotify.addShow(id, 3)
+	}
+	dynamo := newFakeDynamoDB()
+	// 書き込んだ番組を順に記録し、interruptedの間は2番組目の書き込みを失敗させる
+	interrupted := true
+	var written []string
+	dynamo.fail = func(op string, input any) error {
+		batch, ok := input.(*dynamodb.BatchWriteItemInput)
+		if !ok {
+			return nil
+		}
+		for _, requests := range batch.RequestItems {
+			showID := aws.StringValue(requests[0].PutRequest.Item["ShowID"].S)
+			if interrupted && showID == testShowB {
+				return awserr.New("ValidationException", "interrupted", nil)
+			}
+			written = append(written, showID)
+		}
+		return nil
+	}
+	config := testConfig(spotify, dynamo.serve(t))
+	config.CheckpointFile = filepath.Join(t.TempDir(), "checkpoints.json")
+	shows := testShowA + "," + testShowB + "," + testShowC
+
+	if code := runCommand(t, config, "fetch", "-show", shows); code == 0 {
+		t.Fatal("interrupted run succeeded")
+	}
+	checkpoints, err := LoadCheckpoints(config.CheckpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Checkpoints{testShowA: 3}); !reflect.DeepEqual(checkpoints, want) {
+		t.Fatalf("checkpoints = %v, want %v", checkpoints, want)
+	}
+
+	interrupted = false
+	written = nil
+	pagesOfA := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes"))
+	if code := runCommand(t, config, "fetch", "-show", shows); code != 0 {
+		t.Fatalf("resumed run exit code = %d, want 0", code)
+	}
+
+	// 終わった番組は1ページ目で総数を確かめるだけで、取得も書き込みもしない
+	if want := []string{testShowB, testShowC}; !reflect.DeepEqual(written, want) {
+		t.Errorf("resumed run wrote shows %v, want %v", written, want)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/"+testShowA+"/episodes")) - pagesOfA; n != 0 {
+		t.Errorf("resumed run fetched %d more pages of the finished show", n)
+	}
+	if _, err := os.Stat(config.CheckpointFile); !os.IsNotExist(err) {
+		t.Errorf("checkpoint file remains after a complete run: %v", err)
+	}
+}
+
+// 204では番組情報が空なので、要求した番組IDでチェックポイントを残す
+func TestFetchCheckpointsNoContentShow(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowB, 3)
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path == "/v1/shows/"+testShowA {
+			w.WriteHeader(http.StatusNoContent)
+			return true
+		}
+		return false
+	}
+	dynamo := newFakeDynamoDB()
+	// 2番組目の書き込みで中断する
+	dynamo.fail = func(op string, input any) error {
+		if op == "BatchWriteItem" {
+			return awserr.New("ValidationException", "interrupted", nil)
+		}
+		return nil
+	}
+	config := testConfig(spotify, dynamo.serve(t))
+	config.CheckpointFile = filepath.Join(t.TempDir(), "checkpoints.json")
+
+	if code := runCommand(t, config, "fetch", "-allow-empty", "-show", testShowA+","+testShowB); code == 0 {
+		t.Fatal("interrupted run succeeded")
+	}
+
+	checkpoints, err := LoadCheckpoints(config.CheckpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Checkpoints{testShowA: 0}); !reflect.DeepEqual(checkpoints, want) {
+		t.Errorf("checkpoints = %v, want %v", checkpoints, want)
+	}
+}
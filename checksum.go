@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// path.sha256 にsha256sum形式でチェックサムを書く
+func WriteChecksum(path string) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path+".sha256", []byte(sum+"  "+filepath.Base(path)+"\n"), 0644)
+}
+
+// path.sha256 (sha256sum形式) のチェックサムとファイルの内容を照合する
+func VerifyChecksum(path string) error {
+	data, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		return fmt.Errorf("failed to read checksum file: %w", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("checksum file %s.sha256 is empty", path)
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(fields[0], sum) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", path, fields[0], sum)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportChecksum(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	path := filepath.Join(t.TempDir(), "episodes.json")
+
+	if code := runCommand(t, config, "fetch", "-export", path, "-show", testShowA); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	sidecar, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(sum[:]) + "  episodes.json\n"; string(sidecar) != want {
+		t.Errorf("checksum file = %q, want %q", sidecar, want)
+	}
+
+	// 改ざんしていなければ読み込める
+	imported := newFakeDynamoDB()
+	config = testConfig(spotify, imported.serve(t))
+	if code := runCommand(t, config, "fetch", "-import", path, "-verify-checksum"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if n := len(imported.ids(config.tableName())); n != 3 {
+		t.Errorf("%d items imported, want 3", n)
+	}
+
+	err = os.WriteFile(path, append(data, ' '), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := newFakeDynamoDB()
+	config = testConfig(spotify, tampered.serve(t))
+	if code := runCommand(t, config, "fetch", "-import", path, "-verify-checksum"); code == 0 {
+		t.Error("tampered import file was accepted")
+	}
+	if tampered.hasTable(config.tableName()) {
+		t.Error("tampered import file was written to DynamoDB")
+	}
+}
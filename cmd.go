@@ -0,0 +1,861 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// サブコマンド名と実行する関数
+var commands = []struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}{
+	{"fetch", "fetch episodes from Spotify (or -import a file) and write them to DynamoDB", runFetch},
+	{"search", "search Spotify for shows matching a query and print their IDs", runSearch},
+	{"create-table", "create the episode and show tables if they do not exist", runCreateTable},
+	{"delete-table", "delete the episode table", runDeleteTable},
+	{"list", "print the name and release date of every stored episode", runList},
+	{"benchmark", "write synthetic episodes to a scratch table and report write throughput", runBenchmark},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// サブコマンド共通の準備。ログ設定と設定ファイルの読込を行い、
+// コマンドが使うサービスの設定だけを必須として確認する
+func loadCommandConfig(logFormat, logLevel string, required Requirement) (Config, error) {
+	err := SetupLogging(logFormat, logLevel)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config, err := LoadConfig("config.json")
+	if err != nil {
+		return Config{}, err
+	}
+
+	// 設定の不足でSpotifyやAWSへの無駄なリクエストをしない
+	err = config.Validate(required)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+// エラーは記録・報告してから返し、終了コードはmainで決める
+func runFetch(args []string) (err error) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	importPath := fs.String("import", "", "import episodes from a previously exported JSON file instead of fetching from Spotify")
+	exportPath := fs.String("export", "", "also export the episodes to this file: CSV if it ends in .csv, JSON Lines if it ends in .jsonl, JSON otherwise (with a .sha256 sidecar)")
+	exportImages := fs.String("export-images", "", "add image URLs to the -export file: largest (the largest image) or all")
+	exportNameLength := fs.Int("export-name-length", 0, "truncate episode names in the -export file to this many characters with an ellipsis (0: no limit)")
+	exportFieldNames := fs.String("export-field-names", ExportFieldsSpotify, "JSON field names of the -export file: spotify (snake_case, can be -import-ed) or camel (camelCase)")
+	exportAppend := fs.Bool("export-append", false, "resume an interrupted .jsonl -export by appending only the episodes not yet in the file")
+	archivePath := fs.String("archive", "", "also write the episodes as JSON, CSV and an RSS feed with a manifest to this .tar.gz file")
+	splitByShow := fs.Bool("split-by-show", false, "write a separate -export file for each show, named after the show")
+	compact := fs.Bool("compact", false, "write the -export JSON without indentation or newlines")
+	verifyChecksum := fs.Bool("verify-checksum", false, "verify the -import file against its .sha256 sidecar before loading")
+	skipExternal := fs.Bool("skip-external", false, "skip externally-hosted shows and episodes")
+	playableOnly := fs.Bool("playable-only", false, "skip episodes that are not playable (is_playable=false)")
+	skipExplicit := fs.Bool("skip-explicit", false, "skip explicit episodes")
+	market := fs.String("market", "", "market (country code) the episodes are for; warn if a show is not available there (overrides market in config)")
+	from := fs.String("from", "", "only keep episodes released on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only keep episodes released on or before this date (YYYY-MM-DD)")
+	since := fs.String("since", "", "same as -from, e.g. the date of the last run (YYYY-MM-DD)")
+	printStats := fs.Bool("stats", false, "print aggregate statistics for the episodes")
+	showRefs := fs.String("show", "", "comma-separated show IDs, spotify:show: URIs or open.spotify.com URLs (overrides show_ids in config)")
+	logFormat := fs.String("log-format", "text", "log output format: text or json")
+	logLevel := fs.String("log-level", "info", "minimum level of structured log events: debug, info, warn or error")
+	order := fs.String("order", "", "order episodes before writing: popularity or oldest (default: fetch order)")
+	explain := fs.Bool("explain", false, "log the pagination decision for each page")
+	concurrency := fs.Int("concurrency", 1, "fetch the pages after the first one with this many concurrent requests")
+	allowPartial := fs.Bool("allow-partial", false, "write the pages fetched so far when a later page fails")
+	allowEmpty := fs.Bool("allow-empty", false, "continue the sync even when the show has no episodes")
+	checkPreviews := fs.Bool("check-previews", false, "report episodes whose audio preview URL is unreachable")
+	previewConcurrency := fs.Int("preview-concurrency", 4, "number of concurrent audio preview checks")
+	previewRate := fs.Int("preview-rate", 10, "maximum audio preview checks per second")
+	skipInvalid := fs.Bool("skip-invalid", false, "skip episodes missing a Name, ID or ReleaseDate")
+	fs.BoolVar(&quietErrors, "quiet-errors", false, "log errors as warnings and always exit with status 0")
+	cpuProfile := fs.String("profile", "", "write a CPU profile for the run to this file")
+	memProfile := fs.String("memprofile", "", "write a heap profile to this file on exit")
+	reportName := fs.String("report", "noop", "where to report run statistics: stdout-json or noop")
+	auditConfig := fs.Bool("audit-config", false, "check Spotify credentials and DynamoDB permissions without changing data, then exit")
+	rebuild := fs.Bool("rebuild", false, "delete and recreate the tables before writing instead of upserting")
+	dryRun := fs.Bool("dry-run", false, "fetch and filter as usual, but print a summary instead of touching DynamoDB")
+	plan := fs.Bool("plan", false, "write to a scratch table, print the changes against the live table and ask before applying them")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	fs.Parse(args)
+
+	// Ctrl-Cで実行中のリクエストを止める
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// 実行結果の報告。途中で失敗した場合もエラーを記録して報告する
+	sink, err := LookupReportSink(*reportName)
+	if err != nil {
+		return err
+	}
+	stats := RunStats{StartedAt: time.Now()}
+	defer func() {
+		stats.Duration = time.Since(stats.StartedAt)
+		if err != nil {
+			stats.Error = err.Error()
+		}
+		// 中断された場合も報告する
+		reportErr := sink.Report(context.WithoutCancel(ctx), stats)
+		if reportErr != nil {
+			slog.Error("failed to report run stats", "error", reportErr)
+		}
+	}()
+
+	// 取込ならSpotify、dry-runならDynamoDBは使わない
+	required := RequireSpotify | RequireDynamoDB
+	if *importPath != "" {
+		required &^= RequireSpotify
+	}
+	if *dryRun {
+		required &^= RequireDynamoDB
+	}
+	if *auditConfig {
+		required = RequireSpotify | RequireDynamoDB
+	}
+	if *printConfig {
+		required = 0
+	}
+
+	config, err := loadCommandConfig(*logFormat, *logLevel, required)
+	if err != nil {
+		return err
+	}
+
+	stopProfile, err := StartProfile(*cpuProfile, *memProfile)
+	if err != nil {
+		return fmt.Errorf("failed to start profiling: %w", err)
+	}
+	defer func() {
+		err := stopProfile()
+		if err != nil {
+			slog.Error("failed to write profile", "error", err)
+		}
+	}()
+
+	if *plan && *rebuild {
+		return errors.New("-plan cannot be combined with -rebuild")
+	}
+	if *dryRun && *plan {
+		return errors.New("-dry-run cannot be combined with -plan")
+	}
+	// スクラッチテーブルはメインのテーブルの分しか作らないので、言語別のテーブルの差分は出せない
+	if *plan && config.SplitByLanguage {
+		return errors.New("-plan cannot be used with split_by_language")
+	}
+
+	// -since は -from の別名
+	if *since != "" {
+		if *from != "" {
+			return errors.New("-since and -from cannot be used together")
+		}
+		_, err := time.Parse(time.DateOnly, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since date %q: expected YYYY-MM-DD", *since)
+		}
+		*from = *since
+	}
+
+	window, err := ParseDateWindow(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	// -print-config にも反映する
+	if *market != "" {
+		config.Market = *market
+	}
+
+	// 設定表示
+	if *printConfig {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(config.Redacted())
+		if err != nil {
+			return fmt.Errorf("failed to print config: %w", err)
+		}
+		return nil
+	}
+
+	err = config.ValidateExtraAttributes()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	err = config.ValidateKeyNormalization()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	err = config.ValidateExtraHeaders()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	err = config.ValidateRetryJitter()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	err = config.ValidateNotifier()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	err = config.ValidateAttributeMapping()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// 処理できなかったものの記録先。設定が無ければnilのまま何も書かない
+	var deadLetters *DeadLetterWriter
+	if config.DeadLetterFile != "" {
+		deadLetters, err = OpenDeadLetters(config.DeadLetterFile)
+		if err != nil {
+			return fmt.Errorf("failed to open dead letter file: %w", err)
+		}
+		defer deadLetters.Close()
+	}
+
+	// トレース設定
+	shutdown, err := SetupTracing(ctx, config)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer shutdown(ctx)
+
+	ctx, span := tracer.Start(ctx, "run")
+	defer span.End()
+
+	// Spotifyへのリクエストはすべて同じクライアントで接続を使い回す
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		return err
+	}
+
+	// 権限の確認
+	if *auditConfig {
+		svc, err := newDynamoClient(config)
+		if err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+
+		results := []AuditResult{AuditSpotify(ctx, client, config)}
+		results = append(results, AuditDynamoDB(ctx, svc, config.tableName())...)
+		if !PrintAudit(os.Stdout, results) {
+			return errors.New("some required permissions are missing or could not be checked")
+		}
+		return nil
+	}
+
+	// 対象の番組。-show があれば config の show_ids より優先する
+	refs := config.ShowIDs
+	if *showRefs != "" {
+		refs = strings.Split(*showRefs, ",")
+	}
+	if len(refs) == 0 {
+		refs = []string{defaultShowID}
+	}
+
+	var programs []string
+	// 書き込まずに飛ばした番組。-rebuildではそのデータが消えるので作り直さない
+	var skippedShows []string
+	for _, ref := range refs {
+		program, err := ParseShowRef(ref)
+		if err != nil {
+			slog.Warn("skipping invalid show", "show", ref, "error", err)
+			skippedShows = append(skippedShows, ref)
+			continue
+		}
+		programs = append(programs, program)
+	}
+
+	stats.Shows = programs
+
+	var items []Item
+	var states ShowStates
+	// 取得に失敗した番組
+	var failed []string
+	// 書き込み後に履歴と状態を残す番組
+	var synced []syncedShow
+	syncedStates := make(ShowStates)
+	// 番組ごとのチェックポイント。取得に失敗した番組があればサイクルを終えない
+	var checkpoints Checkpoints
+	partial := make(map[string]bool)
+	var incomplete bool
+
+	if *importPath != "" {
+		// インポート(Spotifyにはアクセスしない)
+		if *verifyChecksum {
+			err = VerifyChecksum(*importPath)
+			if err != nil {
+				return fmt.Errorf("failed to verify import file: %w", err)
+			}
+		}
+
+		items, err = ImportItems(*importPath)
+		if err != nil {
+			return fmt.Errorf("failed to import episodes: %w", err)
+		}
+		stats.Fetched = len(items)
+	} else {
+		// 前回の状態読込
+		if config.StateFile != "" {
+			states, err = LoadShowStates(config.StateFile)
+			if err != nil {
+				return fmt.Errorf("failed to load state file: %w", err)
+			}
+		}
+
+		// 中断した前回の実行で書き込み済みの番組
+		if config.CheckpointFile != "" {
+			checkpoints, err = LoadCheckpoints(config.CheckpointFile)
+			if err != nil {
+				return fmt.Errorf("failed to load checkpoint file: %w", err)
+			}
+			if *rebuild && len(checkpoints) > 0 {
+				return fmt.Errorf("cannot -rebuild while resuming from %s; remove it to start over", config.CheckpointFile)
+			}
+		}
+
+		manager := NewTokenManager(client, config)
+		filters := SyncFilters{
+			From:         *from,
+			To:           *to,
+			Market:       config.Market,
+			SkipExternal: *skipExternal,
+			SkipExplicit: *skipExplicit,
+			PlayableOnly: *playableOnly,
+			SkipInvalid:  *skipInvalid,
+		}
+
+		for _, program := range programs {
+			var state *ShowState
+			if states != nil {
+				s := states[program]
+				// 絞り込みが前回と違えば、条件付きGETや総エピソード数では書き込みを省けない
+				if s.Filters != filters {
+					s = ShowState{Filters: filters}
+				}
+				state = &s
+			}
+
+			result, err := FetchItems(ctx, config, manager, program, FetchOptions{
+				Window:       window,
+				State:        state,
+				AllowPartial: *allowPartial,
+				Explain:      *explain,
+				Concurrency:  *concurrency,
+				Checkpoint:   checkpoints[program],
+			})
+			if err != nil {
+				slog.Error("failed to fetch show, skipping", "show", program, "error", err)
+				failed = append(failed, program)
+				deadLetters.Record(DeadLetterShow, program, "", err)
+				incomplete = true
+				continue
+			}
+			pi, showItems := result.Info, result.Items
+			stats.Fetched += len(showItems)
+
+			if result.Unchanged && checkpoints[program] > 0 {
+				slog.Info("show was already synced in this cycle, skipping", "show", program, "episodes", pi.TotalEpisodes)
+				continue
+			}
+			if result.Unchanged {
+				slog.Info("show has not changed since the last run, skipping sync", "show", program)
+				stats.Unchanged++
+				skippedShows = append(skippedShows, program)
+				continue
+			}
+
+			// 一時的に空が返っただけの可能性があるので、既定では何も書かない
+			if pi.TotalEpisodes == 0 && len(showItems) == 0 && !*allowEmpty {
+				slog.Warn("show returned no episodes, skipping sync (use -allow-empty to continue)", "show", program)
+				skippedShows = append(skippedShows, program)
+				continue
+			}
+
+			// 取得が途中で終わった場合は既存データを残してこの番組は書かない
+			// (-fromでページングを止めた場合は対象外)
+			if window.From.IsZero() && pi.TotalEpisodes > 0 {
+				completeness := float64(len(showItems)) / float64(pi.TotalEpisodes) * 100
+				if completeness < config.MinCompletenessPercent {
+					slog.Warn("too few episodes fetched, skipping without writing", "show", program, "fetched", len(showItems), "total", pi.TotalEpisodes, "completeness_percent", completeness, "min_completeness_percent", config.MinCompletenessPercent)
+					skippedShows = append(skippedShows, program)
+					continue
+				}
+			}
+
+			if LooksUnavailable(showItems) {
+				slog.Warn("no episode is playable or has an audio preview; the token may lack access to this content or the market may be misconfigured",
+					"show", program, "episodes", len(showItems))
+			}
+
+			// 部分的な取得では次回すべて取り直せるよう状態を保存しない
+			if result.Partial {
+				slog.Warn("sync of show is partial", "show", program, "fetched", len(showItems), "total", pi.TotalEpisodes)
+				stats.Partial = true
+				state = nil
+				partial[program] = true
+				incomplete = true
+			}
+
+			if config.Market != "" && len(pi.AvailableMarkets) > 0 && !slices.Contains(pi.AvailableMarkets, strings.ToUpper(config.Market)) {
+				slog.Warn("show is not available in the market", "show", program, "market", config.Market)
+			}
+
+			if *skipExternal && pi.IsExternallyHosted {
+				slog.Info("skipping externally-hosted show", "show", pi.ID, "episodes", len(showItems))
+				skippedShows = append(skippedShows, program)
+				continue
+			}
+
+			items = append(items, showItems...)
+			synced = append(synced, syncedShow{Program: program, Info: pi})
+			if state != nil {
+				syncedStates[program] = *state
+			}
+		}
+	}
+
+	// 公開日で絞り込み
+	if !window.IsZero() {
+		items = window.Filter(items)
+	}
+
+	// 外部ホストのエピソードを除外
+	if *skipExternal {
+		var skipped int
+		items, skipped = SkipExternallyHosted(items)
+		slog.Info("skipped externally-hosted episodes", "count", skipped)
+	}
+
+	// 成人向けのエピソードを除外
+	if *skipExplicit {
+		var skipped int
+		items, skipped = SkipExplicit(items)
+		slog.Info("skipped explicit episodes", "count", skipped)
+	}
+
+	// 再生できないエピソードを除外
+	if *playableOnly {
+		var skipped int
+		items, skipped = SkipUnplayable(items)
+		slog.Info("skipped unplayable episodes", "count", skipped)
+	}
+
+	// 必須項目の欠けたエピソードを報告
+	problems := FindInvalidItems(items)
+	for _, problem := range problems {
+		slog.Warn("invalid episode", "problem", problem)
+	}
+	if *skipInvalid && len(problems) > 0 {
+		for _, problem := range problems {
+			deadLetters.Record(DeadLetterEpisode, problem.Item.ID, problem.Item.ShowID, fmt.Errorf("missing %s", strings.Join(problem.Missing, ", ")))
+		}
+		items = SkipInvalidItems(items)
+		slog.Warn("skipped invalid episodes", "count", len(problems))
+	}
+
+	// HTMLエンティティのデコード
+	if config.DecodeHTMLEntities {
+		DecodeHTMLEntities(items)
+	}
+
+	// 統計表示
+	if *printStats {
+		ShowStats(items).Print(os.Stdout)
+	}
+
+	// プレビュー音声の到達確認
+	if *checkPreviews {
+		failures := CheckPreviews(ctx, items, *previewConcurrency, *previewRate)
+		PrintPreviewReport(os.Stdout, failures)
+	}
+
+	// 同じエピソードが複数回返った場合は1つにする
+	items, duplicates := DedupByID(items)
+	if duplicates > 0 {
+		slog.Info("skipped duplicate episodes", "count", duplicates)
+	}
+
+	// 書き込み順の並べ替え
+	err = OrderItems(items, *order)
+	if err != nil {
+		return err
+	}
+
+	// ファイルへの書き出し
+	exportOptions := ExportOptions{Compact: *compact, Images: *exportImages, MaxNameRunes: *exportNameLength, FieldNames: *exportFieldNames, Append: *exportAppend}
+	if *exportPath != "" {
+		paths := []string{*exportPath}
+		if *splitByShow {
+			// 番組名はSpotifyから取得した場合だけ分かる
+			names := make(map[string]string)
+			for _, show := range synced {
+				names[show.Program] = show.Info.Name
+			}
+			paths, err = ExportItemsByShow(items, ExportFormat(*exportPath), *exportPath, exportOptions, names)
+		} else {
+			err = ExportItems(items, ExportFormat(*exportPath), *exportPath, exportOptions)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to export episodes: %w", err)
+		}
+		for _, path := range paths {
+			err = WriteChecksum(path)
+			if err != nil {
+				return fmt.Errorf("failed to write checksum: %w", err)
+			}
+		}
+		slog.Info("exported episodes", "count", len(items), "files", paths)
+	}
+
+	// JSON、CSV、RSSフィードをまとめたアーカイブ
+	if *archivePath != "" {
+		title := "Podcast episodes"
+		if len(synced) == 1 && synced[0].Info.Name != "" {
+			title = synced[0].Info.Name
+		}
+		exportOptions.Append = false
+		err = ExportArchive(items, *archivePath, title, exportOptions)
+		if err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		err = WriteChecksum(*archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to write checksum: %w", err)
+		}
+		slog.Info("archived episodes", "count", len(items), "file", *archivePath)
+	}
+
+	// DynamoDBには接続せず、書き込む予定の内容だけ表示する。状態や履歴も残さない
+	if *dryRun {
+		PrintDryRun(os.Stdout, items)
+		return nil
+	}
+
+	// スクラッチテーブルで差分を確認し、承認されたものだけ書く
+	if *plan {
+		p, err := PlanSync(ctx, config, items)
+		if err != nil {
+			return fmt.Errorf("failed to plan sync: %w", err)
+		}
+		p.Print(os.Stdout)
+
+		items = p.Items()
+		if len(items) == 0 {
+			return nil
+		}
+		if !confirm(os.Stdin, os.Stdout, fmt.Sprintf("Apply %d changes to %s?", len(items), p.Table)) {
+			slog.Info("plan not applied")
+			return nil
+		}
+	}
+
+	// 既存データを消して作り直す。すべての番組をそろえて取得できた場合に限る
+	if *rebuild {
+		err = checkRebuild(stats.Partial || len(failed) > 0, skippedShows, len(items), *allowEmpty)
+		if err != nil {
+			return err
+		}
+
+		err = RebuildTables(ctx, config, items)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild tables: %w", err)
+		}
+	}
+
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	var result WriteResult
+	if checkpoints != nil {
+		result, err = putItemsByShow(ctx, svc, config, items, synced, partial, checkpoints, WriteOptions{DeadLetters: deadLetters})
+	} else {
+		result, err = writeItems(ctx, svc, config, items, WriteOptions{DeadLetters: deadLetters})
+	}
+	// 途中で失敗しても書き込めた件数は残す
+	slog.Info("wrote episodes", "count", result.Written)
+	if err != nil {
+		return fmt.Errorf("failed to write episodes: %w", err)
+	}
+	stats.Written = result.Written
+	stats.New = len(result.New)
+	stats.Skipped = result.Skipped
+	slog.Info("items written", "count", stats.Written, "new", stats.New, "skipped", stats.Skipped)
+
+	// 新着エピソードを通知
+	err = NotifyNewEpisodes(ctx, config, result.New)
+	if err != nil {
+		slog.Error("failed to send notification", "error", err)
+	}
+
+	// 番組の情報を記録
+	if config.RecordShows {
+		for _, show := range synced {
+			// 204では番組情報が無い
+			if show.Info.ID == "" {
+				continue
+			}
+			err = PutShow(ctx, svc, config, show.Info)
+			if err != nil {
+				slog.Error("failed to record show", "show", show.Program, "error", err)
+			}
+		}
+	}
+
+	// 総エピソード数の推移を記録
+	if config.RecordHistory {
+		for _, show := range synced {
+			err = PutHistory(ctx, svc, config, show.Program, show.Info.TotalEpisodes, time.Now())
+			if err != nil {
+				// 履歴が欠けてもエピソードの書き込みは済んでいる
+				slog.Error("failed to record history", "show", show.Program, "error", err)
+			}
+		}
+	}
+
+	// 今回の状態を保存
+	if len(syncedStates) > 0 {
+		for program, state := range syncedStates {
+			states[program] = state
+		}
+		err = SaveShowStates(config.StateFile, states)
+		if err != nil {
+			return fmt.Errorf("failed to save state file: %w", err)
+		}
+	}
+
+	// すべての番組を書き終えたら次の実行は最初から
+	if checkpoints != nil && !incomplete {
+		err = ClearCheckpoints(config.CheckpointFile)
+		if err != nil {
+			slog.Error("failed to remove checkpoint file", "path", config.CheckpointFile, "error", err)
+		}
+	}
+
+	// 他の番組の書き込みは済ませたうえで、取得できなかった番組があれば失敗とする
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to fetch %d of %d shows: %s", len(failed), len(programs), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	limit := fs.Int("limit", 10, "maximum number of shows printed (1-50)")
+	logFormat := fs.String("log-format", "text", "log output format: text or json")
+	logLevel := fs.String("log-level", "info", "minimum level of structured log events: debug, info, warn or error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s search [flags] <query>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config, err := loadCommandConfig(*logFormat, *logLevel, RequireSpotify)
+	if err != nil {
+		return err
+	}
+
+	err = config.ValidateExtraHeaders()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		return err
+	}
+
+	shows, err := SearchShows(ctx, NewTokenManager(client, config), query, *limit)
+	if err != nil {
+		return fmt.Errorf("failed to search shows: %w", err)
+	}
+	PrintShows(os.Stdout, shows)
+	return nil
+}
+
+func runCreateTable(args []string) error {
+	return runTableCommand("create-table", args, func(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, tableName string) error {
+		err := EnsureTable(ctx, svc, tableName)
+		if err != nil {
+			return err
+		}
+		return EnsureShowTable(ctx, svc, config.showTableName())
+	})
+}
+
+func runDeleteTable(args []string) error {
+	return runTableCommand("delete-table", args, func(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, tableName string) error {
+		return DeleteTable(ctx, svc, tableName)
+	})
+}
+
+// 設定とテーブル名を受け取ってcreate-table/delete-tableを実行する
+func runTableCommand(name string, args []string, fn func(context.Context, dynamodbiface.DynamoDBAPI, Config, string) error) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	table := fs.String("table", "", "table name (default: table_name in config)")
+	logFormat := fs.String("log-format", "text", "log output format: text or json")
+	logLevel := fs.String("log-level", "info", "minimum level of structured log events: debug, info, warn or error")
+	fs.Parse(args)
+
+	config, err := loadCommandConfig(*logFormat, *logLevel, RequireDynamoDB)
+	if err != nil {
+		return err
+	}
+
+	tableName := *table
+	if tableName == "" {
+		tableName = config.tableName()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	err = fn(ctx, svc, config, tableName)
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", strings.Replace(name, "-", " ", 1), tableName, err)
+	}
+	slog.Info(name+" done", "table", tableName)
+	return nil
+}
+
+func runBenchmark(args []string) error {
+	fs := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	count := fs.Int("n", 1000, "number of synthetic episodes to write")
+	concurrency := fs.Int("concurrency", 1, "number of concurrent BatchWriteItem requests (the minimum with -max-concurrency)")
+	maxConcurrency := fs.Int("max-concurrency", 0, "raise the concurrency up to this many requests while nothing is throttled, and halve it when writes are throttled")
+	table := fs.String("table", "", "table to write to (default: table_name in config with a -benchmark suffix)")
+	keep := fs.Bool("keep", false, "keep the benchmark table instead of deleting it afterwards")
+	logFormat := fs.String("log-format", "text", "log output format: text or json")
+	logLevel := fs.String("log-level", "info", "minimum level of structured log events: debug, info, warn or error")
+	fs.Parse(args)
+
+	config, err := loadCommandConfig(*logFormat, *logLevel, RequireDynamoDB)
+	if err != nil {
+		return err
+	}
+
+	tableName := *table
+	if tableName == "" {
+		tableName = config.tableName() + "-benchmark"
+	}
+	// 本番のテーブルを消さない
+	if tableName == config.tableName() && !*keep {
+		return fmt.Errorf("refusing to delete the episode table %s after the benchmark; use -keep or another -table", tableName)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	if !*keep {
+		defer func() {
+			err := DeleteTable(context.WithoutCancel(ctx), svc, tableName)
+			if err != nil {
+				slog.Error("failed to delete benchmark table", "table", tableName, "error", err)
+			}
+		}()
+	}
+
+	result, err := RunBenchmark(ctx, svc, config, tableName, *count, NewAdaptiveConcurrency(*concurrency, max(*concurrency, *maxConcurrency)))
+	result.Print(os.Stdout)
+	if err != nil {
+		return fmt.Errorf("benchmark did not complete: %w", err)
+	}
+	return nil
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	table := fs.String("table", "", "table name (default: table_name in config)")
+	order := fs.String("order", "oldest", "order of the episodes: popularity or oldest")
+	shows := fs.Bool("shows", false, "print the ID, episode count and name of each show in the table instead of the episodes")
+	logFormat := fs.String("log-format", "text", "log output format: text or json")
+	logLevel := fs.String("log-level", "info", "minimum level of structured log events: debug, info, warn or error")
+	fs.Parse(args)
+
+	config, err := loadCommandConfig(*logFormat, *logLevel, RequireDynamoDB)
+	if err != nil {
+		return err
+	}
+	if *table != "" {
+		config.TableName = *table
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	items, err := scanItems(ctx, svc, config.tableName())
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", config.tableName(), err)
+	}
+
+	if *shows {
+		// 番組名はrecord_showsで記録したものがあれば使う
+		names, err := scanByID(ctx, svc, config.showTableName())
+		if err != nil {
+			return err
+		}
+		PrintShowCounts(os.Stdout, CountShows(items, names))
+		return nil
+	}
+
+	err = OrderItems(items, *order)
+	if err != nil {
+		return err
+	}
+	PrintItems(os.Stdout, items)
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestFetchQuietErrors(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	sink := registerCapturingSink(t, "capture")
+
+	// 存在しない番組を指定して取得を失敗させる
+	code := runCommand(t, config, "fetch", "-show", testShowA, "-report", "capture")
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+
+	code = runCommand(t, config, "fetch", "-show", testShowA, "-report", "capture", "-quiet-errors")
+	if code != 0 {
+		t.Errorf("exit code with -quiet-errors = %d, want 0", code)
+	}
+	if stats := sink.last(t); stats.Error == "" {
+		t.Error("RunStats.Error is empty, want the fetch error")
+	}
+}
+
+func TestFetchSkipsIncompleteShow(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	// 10件あるはずが5件しか取れない
+	spotify.addShow(testShowA, 5).Info.TotalEpisodes = 10
+	spotify.addShow(testShowB, 4)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.MinCompletenessPercent = 90
+
+	code := runCommand(t, config, "fetch", "-show", testShowA+","+testShowB)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	for _, item := range dynamo.items(config.tableName()) {
+		if show := aws.StringValue(item["ShowID"].S); show != testShowB {
+			t.Errorf("episode %s of the incomplete show %s was written", aws.StringValue(item["ID"].S), show)
+		}
+	}
+	if n := len(dynamo.items(config.tableName())); n != 4 {
+		t.Errorf("stored %d episodes, want the 4 of the complete show", n)
+	}
+}
+
+func TestFetchEmptyShowKeepsStoredEpisodes(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowC, 0)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	dynamo.seed(config.tableName(), map[string]*dynamodb.AttributeValue{
+		"ID":     {S: aws.String("stored")},
+		"ShowID": {S: aws.String(testShowC)},
+	})
+
+	for _, args := range [][]string{
+		{"fetch", "-show", testShowC},
+		{"fetch", "-show", testShowC, "-allow-empty"},
+	} {
+		code := runCommand(t, config, args...)
+		if code != 0 {
+			t.Fatalf("%v exit code = %d, want 0", args, code)
+		}
+		if ids := dynamo.ids(config.tableName()); !slices.Equal(ids, []string{"stored"}) {
+			t.Errorf("%v left %v, want [stored]", args, ids)
+		}
+	}
+	if n := dynamo.countCalls("DeleteTable"); n != 0 {
+		t.Errorf("DeleteTable called %d times, want 0", n)
+	}
+}
+
+func TestFetchMultipleShows(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	spotify.addShow(testShowB, 2)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	// 形式の誤ったIDは警告して残りの番組を取得する
+	if code := runCommand(t, config, "fetch", "-show", testShowA+",not-a-show,"+testShowB); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	got := make(map[string]string)
+	for _, item := range dynamo.items(config.tableName()) {
+		got[aws.StringValue(item["ID"].S)] = aws.StringValue(item["ShowID"].S)
+	}
+	want := map[string]string{
+		"1111-001": testShowA,
+		"1111-002": testShowA,
+		"1111-003": testShowA,
+		"2222-001": testShowB,
+		"2222-002": testShowB,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored show IDs = %v, want %v", got, want)
+	}
+}
+
+func TestRunDispatch(t *testing.T) {
+	for _, args := range [][]string{nil, {"help"}, {"unknown"}} {
+		var code int
+		out := captureStderr(t, func() { code = run(args) })
+		if code != 2 {
+			t.Errorf("run(%q) = %d, want 2", args, code)
+		}
+		for _, c := range commands {
+			if !strings.Contains(out, c.name) {
+				t.Errorf("run(%q) usage does not list %s:\n%s", args, c.name, out)
+			}
+		}
+		if args != nil && args[0] == "unknown" && !strings.Contains(out, `unknown command "unknown"`) {
+			t.Errorf("run(%q) did not name the unknown command:\n%s", args, out)
+		}
+	}
+
+	// 各サブコマンドがそれぞれの処理を呼ぶ
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 2)
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/search" {
+			return false
+		}
+		writeJSON(w, map[string]any{"shows": map[string]any{"items": []ProgramInfo{{ID: testShowA, Name: "Show A"}}}})
+		return true
+	}
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	if code := runCommand(t, config, "create-table"); code != 0 || !dynamo.hasTable(config.tableName()) {
+		t.Errorf("create-table exit code = %d, table created = %t", code, dynamo.hasTable(config.tableName()))
+	}
+
+	var code int
+	out := captureStdout(t, func() { code = runCommand(t, config, "search", "show", "a") })
+	if code != 0 || !strings.Contains(out, testShowA) {
+		t.Errorf("search exit code = %d, output:\n%s", code, out)
+	}
+
+	if code := runCommand(t, config, "fetch", "-show", testShowA); code != 0 || len(dynamo.ids(config.tableName())) != 2 {
+		t.Errorf("fetch exit code = %d, stored IDs = %v", code, dynamo.ids(config.tableName()))
+	}
+
+	out = captureStdout(t, func() { code = runCommand(t, config, "list") })
+	if code != 0 || !strings.Contains(out, "Episode 1") || !strings.Contains(out, "Episode 2") {
+		t.Errorf("list exit code = %d, output:\n%s", code, out)
+	}
+
+	if code := runCommand(t, config, "delete-table"); code != 0 || dynamo.hasTable(config.tableName()) {
+		t.Errorf("delete-table exit code = %d, table remains = %t", code, dynamo.hasTable(config.tableName()))
+	}
+}
+
+// DynamoDBだけを使うコマンドはSpotifyの認証情報が無くても動く
+func TestCommandsWithoutSpotifyConfig(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	config := testConfig(nil, dynamo.serve(t))
+	config.ClientID, config.ClientSecret = "", ""
+
+	if code := runCommand(t, config, "create-table"); code != 0 {
+		t.Fatalf("create-table exit code = %d, want 0", code)
+	}
+	if !dynamo.hasTable(config.tableName()) {
+		t.Fatalf("table %s was not created", config.tableName())
+	}
+	if code := runCommand(t, config, "list"); code != 0 {
+		t.Errorf("list exit code = %d, want 0", code)
+	}
+	if code := runCommand(t, config, "delete-table"); code != 0 {
+		t.Errorf("delete-table exit code = %d, want 0", code)
+	}
+
+	path := filepath.Join(t.TempDir(), "episodes.json")
+	data, err := json.Marshal([]Item{{ID: "ep1", Name: "Episode 1", ShowID: testShowA}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand(t, config, "fetch", "-import", path); code != 0 {
+		t.Fatalf("fetch -import exit code = %d, want 0", code)
+	}
+	if ids := dynamo.ids(config.tableName()); len(ids) != 1 || ids[0] != "ep1" {
+		t.Errorf("stored IDs = %v, want [ep1]", ids)
+	}
+
+	// Spotifyから取得するなら認証情報が要る
+	if code := runCommand(t, config, "fetch", "-show", testShowA); code != 1 {
+		t.Errorf("fetch exit code without credentials = %d, want 1", code)
+	}
+}
+
+func TestFetchRebuildRefusesIncompleteFetch(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	spotify.addShow(testShowC, 0)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	dynamo.seed(config.tableName(), map[string]*dynamodb.AttributeValue{"ID": {S: aws.String("old")}})
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		// testShowBは存在しないので取得に失敗する
+		{"fetch failed", []string{"-show", testShowA + "," + testShowB}},
+		{"invalid show", []string{"-show", testShowA + ",not-a-show"}},
+		{"empty show", []string{"-show", testShowA + "," + testShowC}},
+		{"no episodes", []string{"-show", testShowA, "-from", "2030-01-01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := runCommand(t, config, append([]string{"fetch", "-rebuild"}, tt.args...)...)
+			if code != 1 {
+				t.Errorf("exit code = %d, want 1", code)
+			}
+			if n := dynamo.countCalls("DeleteTable"); n != 0 {
+				t.Errorf("DeleteTable called %d times, want 0", n)
+			}
+			if ids := dynamo.ids(config.tableName()); len(ids) != 1 || ids[0] != "old" {
+				t.Errorf("stored IDs = %v, want [old]", ids)
+			}
+		})
+	}
+
+	code := runCommand(t, config, "fetch", "-rebuild", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if ids := dynamo.ids(config.tableName()); len(ids) != 3 || slices.Contains(ids, "old") {
+		t.Errorf("stored IDs after rebuild = %v, want the 3 fetched episodes only", ids)
+	}
+}
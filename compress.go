@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// 圧縮したDescriptionに付ける DescriptionEncoding の値
+const descriptionEncodingGzip = "gzip+base64"
+
+// Descriptionがthresholdバイトを超えていればgzipしてbase64で格納する
+func compressDescription(attributes map[string]*dynamodb.AttributeValue, threshold int) {
+	description, ok := attributes["Description"]
+	if !ok || threshold <= 0 || len(aws.StringValue(description.S)) <= threshold {
+		return
+	}
+
+	// bytes.Bufferへの書き込みは失敗しない
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(aws.StringValue(description.S)))
+	writer.Close()
+
+	attributes["Description"] = &dynamodb.AttributeValue{
+		S: aws.String(base64.StdEncoding.EncodeToString(buf.Bytes())),
+	}
+	attributes["DescriptionEncoding"] = &dynamodb.AttributeValue{
+		S: aws.String(descriptionEncodingGzip),
+	}
+}
+
+// 読み出した属性のDescriptionを元に戻す。圧縮されていなければそのまま返す
+func decompressDescription(attributes map[string]*dynamodb.AttributeValue) (string, error) {
+	if attributes["Description"] == nil {
+		return "", nil
+	}
+
+	description := aws.StringValue(attributes["Description"].S)
+	if attributes["DescriptionEncoding"] == nil {
+		return description, nil
+	}
+
+	encoding := aws.StringValue(attributes["DescriptionEncoding"].S)
+	if encoding != descriptionEncodingGzip {
+		return "", fmt.Errorf("unknown description encoding %q", encoding)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(description)
+	if err != nil {
+		return "", err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decompressed), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestCompressedDescriptionRoundTrip(t *testing.T) {
+	long := strings.Repeat("A long description of the episode. ", 100)
+	items := testItems(2)
+	items[0].Description = long
+	items[1].Description = "short"
+	dynamo := newFakeDynamoDB()
+	config := Config{CompressDescriptionsOver: 100}
+	ctx := context.Background()
+
+	_, err := writeItems(ctx, dynamo, config, items, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 閾値を超えたものだけ圧縮して格納する
+	stored := make(map[string]string)
+	for _, row := range dynamo.items(config.tableName()) {
+		stored[aws.StringValue(row["ID"].S)] = aws.StringValue(row["Description"].S)
+		if encoding := row["DescriptionEncoding"]; (aws.StringValue(row["ID"].S) == "ep001") != (encoding != nil) {
+			t.Errorf("%s: DescriptionEncoding = %v", aws.StringValue(row["ID"].S), encoding)
+		}
+	}
+	if len(stored["ep001"]) >= len(long) {
+		t.Errorf("long description stored in %d bytes, want fewer than %d", len(stored["ep001"]), len(long))
+	}
+	if stored["ep002"] != "short" {
+		t.Errorf("short description stored as %q", stored["ep002"])
+	}
+
+	read, err := scanItems(ctx, dynamo, config.tableName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptions := make(map[string]string)
+	for _, item := range read {
+		descriptions[item.ID] = item.Description
+	}
+	if descriptions["ep001"] != long || descriptions["ep002"] != "short" {
+		t.Errorf("read back descriptions %q, want the originals", descriptions)
+	}
+
+	item, err := getItem(ctx, dynamo, config.tableName(), "ep001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Description != long {
+		t.Errorf("GetItem description = %q, want the original", item.Description)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "sync"
+
+// スロットリングされた並列数の手前でこの回数続けて成功したら、もう一度その並列数を試す
+const adaptiveProbeInterval = 100
+
+// スロットリングされない範囲で並列数を調整する。
+// 成功が並列数の回数続けば1増やし、スロットリングされれば半分にする(min〜max)。
+// スロットリングされた並列数は覚えておき、しばらくはその手前で止める
+type AdaptiveConcurrency struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	min, max int
+	limit    int
+	inflight int
+	// limitを変えてから続けて成功した回数
+	successes int
+	// スロットリングされた並列数。0なら未知
+	ceiling int
+	// limitを下げるたびに増やす。下げる前に始めたリクエストのスロットリングでは下げ直さない
+	generation int
+	// ceilingの手前でこの回数続けて成功したらceilingを試し直す
+	probeInterval int
+}
+
+// minの並列数から始める。min=maxなら固定の並列数になる
+func NewAdaptiveConcurrency(min, max int) *AdaptiveConcurrency {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+
+	c := &AdaptiveConcurrency{min: min, max: max, limit: min, probeInterval: adaptiveProbeInterval}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// 並列数に空きができるまで待つ。戻り値はReleaseに渡す
+func (c *AdaptiveConcurrency) Acquire() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.inflight >= c.limit {
+		c.cond.Wait()
+	}
+	c.inflight++
+	return c.generation
+}
+
+// Acquireで始めたリクエストの終了。throttledならスロットリングされた
+func (c *AdaptiveConcurrency) Release(generation int, throttled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.cond.Broadcast()
+
+	c.inflight--
+
+	if throttled {
+		if generation == c.generation {
+			c.ceiling = c.limit
+			c.limit = max(c.min, c.limit/2)
+			c.successes = 0
+			c.generation++
+		}
+		return
+	}
+
+	c.successes++
+	// スロットリングされた並列数の手前で止め、しばらく成功が続けば上限を忘れて試し直す
+	if c.ceiling > 0 && c.limit+1 >= c.ceiling {
+		if c.successes < c.probeInterval {
+			return
+		}
+		c.ceiling = 0
+	}
+	if c.successes < c.limit {
+		return
+	}
+	c.successes = 0
+	c.limit = min(c.max, c.limit+1)
+}
+
+// 現在の並列数
+func (c *AdaptiveConcurrency) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrencyBounds(t *testing.T) {
+	c := NewAdaptiveConcurrency(2, 4)
+	if got := c.Limit(); got != 2 {
+		t.Fatalf("initial Limit() = %d, want the minimum 2", got)
+	}
+
+	for range 100 {
+		c.Release(c.Acquire(), false)
+	}
+	if got := c.Limit(); got != 4 {
+		t.Errorf("Limit() after successes = %d, want the maximum 4", got)
+	}
+
+	for range 10 {
+		c.Release(c.Acquire(), true)
+	}
+	if got := c.Limit(); got != 2 {
+		t.Errorf("Limit() after throttling = %d, want the minimum 2", got)
+	}
+
+	// 固定の並列数
+	fixed := NewAdaptiveConcurrency(3, 3)
+	for i := range 50 {
+		fixed.Release(fixed.Acquire(), i%2 == 0)
+	}
+	if got := fixed.Limit(); got != 3 {
+		t.Errorf("Limit() with min = max = 3 is %d", got)
+	}
+}
+
+// 同時にthresholdを超えるリクエストをスロットリングするサーバーに対して、並列数がその手前に落ち着く
+func TestAdaptiveConcurrencySettlesBelowThrottling(t *testing.T) {
+	const threshold = 5
+	c := NewAdaptiveConcurrency(1, 16)
+	c.probeInterval = 1 << 30
+
+	var inflight atomic.Int64
+	request := func() bool {
+		throttled := inflight.Add(1) > threshold
+		time.Sleep(time.Millisecond)
+		inflight.Add(-1)
+		return throttled
+	}
+
+	jobs := make(chan int)
+	var throttled atomic.Int64
+	var lastThrottled atomic.Int64
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				generation := c.Acquire()
+				ok := !request()
+				if !ok {
+					throttled.Add(1)
+					lastThrottled.Store(int64(i))
+				}
+				c.Release(generation, !ok)
+			}
+		}()
+	}
+	const requests = 600
+	for i := range requests {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if throttled.Load() == 0 {
+		t.Fatal("no request was throttled; the concurrency never reached the threshold")
+	}
+	if got := c.Limit(); got > threshold || got < threshold/2 {
+		t.Errorf("Limit() = %d, want it to settle at or below %d", got, threshold)
+	}
+	// 落ち着いた後はスロットリングされない
+	if last := lastThrottled.Load(); last > requests/2 {
+		t.Errorf("request %d of %d was still throttled", last, requests)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const defaultRegion = "us-west-2"
+
+// 環境変数 -> 上書きするConfigのフィールド
+var configEnv = []struct {
+	name  string
+	field func(*Config) *string
+}{
+	{"SPOTIFY_CLIENT_ID", func(c *Config) *string { return &c.ClientID }},
+	{"SPOTIFY_CLIENT_SECRET", func(c *Config) *string { return &c.ClientSecret }},
+	{"SPOTIFY_TOKEN_URL", func(c *Config) *string { return &c.TokenURL }},
+	{"AWS_REGION", func(c *Config) *string { return &c.Region }},
+	{"DYNAMODB_ENDPOINT", func(c *Config) *string { return &c.Endpoint }},
+}
+
+// JSONファイルを読み、環境変数で上書きする。
+// ファイルが無くても、コマンドに必要な項目が環境変数で揃っていればよい
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	file, err := os.Open(path)
+	fileMissing := errors.Is(err, os.ErrNotExist)
+	if err != nil && !fileMissing {
+		return config, fmt.Errorf("failed to open config file: %w", err)
+	}
+	if !fileMissing {
+		defer file.Close()
+
+		err = json.NewDecoder(file).Decode(&config)
+		if err != nil {
+			return config, fmt.Errorf("failed to decode config file: %w", err)
+		}
+
+		err = checkConfigPermissions(file, config.StrictPermissions)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	for _, env := range configEnv {
+		if value, ok := os.LookupEnv(env.name); ok && value != "" {
+			*env.field(&config) = value
+		}
+	}
+
+	return config, nil
+}
+
+// コマンドが使う外部サービス。Validateで確認する項目を決める
+type Requirement int
+
+const (
+	RequireSpotify Requirement = 1 << iota
+	RequireDynamoDB
+)
+
+// requiredのサービスに必要な項目がそろっているか確認する。足りない項目はまとめて1つのエラーで返す
+func (c Config) Validate(required Requirement) error {
+	var missing []string
+	if required&RequireSpotify != 0 {
+		if c.ClientID == "" {
+			missing = append(missing, "client_id (SPOTIFY_CLIENT_ID)")
+		}
+		if c.ClientSecret == "" {
+			missing = append(missing, "client_secret (SPOTIFY_CLIENT_SECRET)")
+		}
+		if c.TokenURL == "" {
+			missing = append(missing, "token_url (SPOTIFY_TOKEN_URL)")
+		}
+	}
+	// DynamoDB Localでなければリージョンが要る
+	if required&RequireDynamoDB != 0 && c.Endpoint == "" && c.Region == "" {
+		missing = append(missing, "region (AWS_REGION)")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required config: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// DynamoDBへの接続設定。endpointがあればDynamoDB Localとみなしてダミーの認証情報を使い、
+// 無ければ環境変数や~/.aws、IAMロールなどAWSの既定の認証情報を使う
+func (c Config) awsConfig() *aws.Config {
+	awsConfig := &aws.Config{
+		Region: aws.String(c.Region),
+	}
+	if c.Endpoint != "" {
+		// DynamoDB Localはリージョンを問わないが、SDKは空を受け付けない
+		if c.Region == "" {
+			awsConfig.Region = aws.String(defaultRegion)
+		}
+		awsConfig.Endpoint = aws.String(c.Endpoint)
+		awsConfig.Credentials = credentials.NewStaticCredentials("dummy", "dummy", "dummy")
+	}
+	return awsConfig
+}
+
+// 設定に従ったDynamoDBのクライアント。呼び出し側はdynamodbiface.DynamoDBAPIとして使う
+func newDynamoClient(config Config) (*dynamodb.DynamoDB, error) {
+	sess, err := session.NewSession(config.awsConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodb.New(sess), nil
+}
+
+// client_secretを含むので、グループやその他のユーザーが読めれば警告する。
+// strict_permissionsが有効ならエラーにする
+func checkConfigPermissions(file *os.File, strict bool) error {
+	// Windowsのパーミッションビットは意味を持たない
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat config file: %w", err)
+	}
+
+	mode := info.Mode().Perm()
+	if mode&0o077 == 0 {
+		return nil
+	}
+
+	if strict {
+		return fmt.Errorf("config file %s is accessible by other users (mode %04o); run chmod 600 %s", file.Name(), mode, file.Name())
+	}
+	slog.Warn("config file is accessible by other users and contains the client secret; run chmod 600 on it", "file", file.Name(), "mode", fmt.Sprintf("%04o", mode))
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// 処理できなかった番組やエピソード。あとで再実行や調査ができるようJSONLで残す
+type DeadLetter struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	ShowID string `json:"show_id,omitempty"`
+	Error  string `json:"error"`
+	Time   string `json:"time"`
+}
+
+const (
+	DeadLetterShow    = "show"
+	DeadLetterEpisode = "episode"
+)
+
+// nilなら何も書かない
+type DeadLetterWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// 追記で開く。前回までの記録は残す
+func OpenDeadLetters(path string) (*DeadLetterWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterWriter{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (d *DeadLetterWriter) Record(kind, id, showID string, err error) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	encodeErr := d.encoder.Encode(DeadLetter{
+		Kind:   kind,
+		ID:     id,
+		ShowID: showID,
+		Error:  err.Error(),
+		Time:   time.Now().UTC().Format(time.RFC3339),
+	})
+	if encodeErr != nil {
+		slog.Error("failed to write dead letter", "kind", kind, "id", id, "error", encodeErr)
+	}
+}
+
+// 書き込めずに残ったリクエストのエピソードを記録する
+func (d *DeadLetterWriter) RecordWriteRequests(requests []*dynamodb.WriteRequest, err error) {
+	for _, request := range requests {
+		if request.PutRequest == nil {
+			continue
+		}
+		attributes := request.PutRequest.Item
+
+		var showID string
+		if attributes["ShowID"] != nil {
+			showID = aws.StringValue(attributes["ShowID"].S)
+		}
+		d.Record(DeadLetterEpisode, aws.StringValue(attributes["ID"].S), showID, err)
+	}
+}
+
+func (d *DeadLetterWriter) Close() error {
+	if d == nil {
+		return nil
+	}
+	return d.file.Close()
+}
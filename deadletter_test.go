@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter DeadLetter
+		err := json.Unmarshal(scanner.Bytes(), &letter)
+		if err != nil {
+			t.Fatalf("invalid dead letter %q: %v", scanner.Text(), err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestFetchRecordsFailedItemAsDeadLetter(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	dynamo.fail = func(op string, input any) error {
+		if put, ok := input.(*dynamodb.PutItemInput); ok && aws.StringValue(put.Item["ID"].S) == "1111-002" {
+			return awserr.New("ValidationException", "item too large", nil)
+		}
+		return nil
+	}
+	config := testConfig(spotify, dynamo.serve(t))
+	// 1件ずつ書く経路にする
+	config.NoClobber = true
+	config.DeadLetterFile = filepath.Join(t.TempDir(), "dead.jsonl")
+
+	code := runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	letters := readDeadLetters(t, config.DeadLetterFile)
+	if len(letters) != 1 {
+		t.Fatalf("%d dead letters, want 1: %+v", len(letters), letters)
+	}
+	if letters[0].Kind != DeadLetterEpisode || letters[0].ID != "1111-002" || letters[0].ShowID != testShowA || letters[0].Error == "" {
+		t.Errorf("dead letter = %+v, want the failed episode", letters[0])
+	}
+	if n := len(dynamo.items(config.tableName())); n != 2 {
+		t.Errorf("stored %d episodes, want the other 2", n)
+	}
+}
+
+func TestWriteBatchRecordsUnprocessedAsDeadLetters(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	// 最初のエピソードはいつまでも処理されない
+	dynamo.unprocessed = func(tableName string, requests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+		for _, request := range requests {
+			if aws.StringValue(request.PutRequest.Item["ID"].S) == "ep001" {
+				return []*dynamodb.WriteRequest{request}
+			}
+		}
+		return nil
+	}
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	deadLetters, err := OpenDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{MaxRetries: 1, RetryBaseDelayMs: 1}
+
+	written, err := batchWriteItems(context.Background(), dynamo, config, testItems(3), WriteOptions{DeadLetters: deadLetters})
+	deadLetters.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 2 {
+		t.Errorf("written = %d, want 2", written)
+	}
+
+	letters := readDeadLetters(t, path)
+	if len(letters) != 1 || letters[0].ID != "ep001" {
+		t.Errorf("dead letters = %+v, want ep001", letters)
+	}
+
+	// 記録先が無ければエラーにする
+	_, err = batchWriteItems(context.Background(), dynamo, config, testItems(3), WriteOptions{})
+	if err == nil {
+		t.Error("batchWriteItems() without dead letters succeeded, want an error")
+	}
+}
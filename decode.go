@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// itemToAttributesで書いた属性からエピソードを組み立て直す。
+// attribute_mappingで書いた属性には対応しない
+func attributesToItem(attributes map[string]*dynamodb.AttributeValue) (Item, error) {
+	var item Item
+	var err error
+
+	str := func(name string) string {
+		if attributes[name] == nil {
+			return ""
+		}
+		return aws.StringValue(attributes[name].S)
+	}
+
+	item.ID = str("ID")
+	item.Name = str("Name")
+	item.ReleaseDate = str("ReleaseDate")
+	item.ExternalUrls.Spotify = str("SpotifyURL")
+	item.AudioPreviewURL = str("AudioPreviewURL")
+	item.ShowID = str("ShowID")
+
+	item.Description, err = decompressDescription(attributes)
+	if err != nil {
+		return item, fmt.Errorf("episode %s: %w", item.ID, err)
+	}
+
+	if value := attributes["DurationMs"]; value != nil && value.N != nil {
+		item.DurationMs, err = strconv.ParseInt(*value.N, 10, 64)
+		if err != nil {
+			return item, fmt.Errorf("episode %s: invalid DurationMs: %w", item.ID, err)
+		}
+	}
+	if value := attributes["Explicit"]; value != nil {
+		item.Explicit = aws.BoolValue(value.BOOL)
+	}
+	if value := attributes["Popularity"]; value != nil && value.N != nil {
+		popularity, err := strconv.Atoi(*value.N)
+		if err != nil {
+			return item, fmt.Errorf("episode %s: invalid Popularity: %w", item.ID, err)
+		}
+		item.Popularity = &popularity
+	}
+
+	if value := attributes["Images"]; value != nil {
+		for _, image := range value.L {
+			decoded, err := attributeToImage(image)
+			if err != nil {
+				return item, fmt.Errorf("episode %s: invalid Images: %w", item.ID, err)
+			}
+			item.Images = append(item.Images, decoded)
+		}
+	}
+	if value := attributes["Languages"]; value != nil {
+		item.Languages = aws.StringValueSlice(value.SS)
+	}
+
+	return item, nil
+}
+
+func attributeToImage(value *dynamodb.AttributeValue) (Image, error) {
+	var image Image
+	var err error
+
+	if value.M == nil {
+		return image, fmt.Errorf("image is not a map")
+	}
+	if url := value.M["URL"]; url != nil {
+		image.URL = aws.StringValue(url.S)
+	}
+	if height := value.M["Height"]; height != nil && height.N != nil {
+		image.Height, err = strconv.Atoi(*height.N)
+		if err != nil {
+			return image, err
+		}
+	}
+	if width := value.M["Width"]; width != nil && width.N != nil {
+		image.Width, err = strconv.Atoi(*width.N)
+		if err != nil {
+			return image, err
+		}
+	}
+
+	return image, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestImagesAndLanguagesRoundTrip(t *testing.T) {
+	items := testItems(2)
+	items[0].Images = []Image{
+		{URL: "https://i.scdn.co/image/large", Height: 640, Width: 640},
+		{URL: "https://i.scdn.co/image/small", Height: 64, Width: 64},
+	}
+	items[0].Languages = []string{"en", "ja"}
+	dynamo := newFakeDynamoDB()
+	config := Config{}
+	ctx := context.Background()
+
+	_, err := writeItems(ctx, dynamo, config, items, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := scanItems(ctx, dynamo, config.tableName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byID := make(map[string]Item)
+	for _, item := range read {
+		byID[item.ID] = item
+	}
+	for _, want := range items {
+		got := byID[want.ID]
+		if !reflect.DeepEqual(got.Images, want.Images) {
+			t.Errorf("%s: Images = %+v, want %+v", want.ID, got.Images, want.Images)
+		}
+		if !reflect.DeepEqual(got.Languages, want.Languages) {
+			t.Errorf("%s: Languages = %v, want %v", want.ID, got.Languages, want.Languages)
+		}
+	}
+}
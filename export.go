@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ExportJSON  = "json"
+	ExportJSONL = "jsonl"
+	ExportCSV   = "csv"
+)
+
+// 出力に含める画像URL
+const (
+	ExportImagesLargest = "largest"
+	ExportImagesAll     = "all"
+)
+
+// JSONのフィールド名。spotifyはSpotifyのAPIと同じsnake_caseで -import で読み込める
+const (
+	ExportFieldsSpotify = "spotify"
+	ExportFieldsCamel   = "camel"
+)
+
+var exportCSVHeader = []string{"Name", "ID", "ReleaseDate", "DurationMs", "Explicit", "SpotifyURL"}
+
+type ExportOptions struct {
+	// JSONをインデントや改行無しで書く
+	Compact bool
+	// 空でなければ画像URLを加える。largestは最も大きい画像、allはすべて
+	Images string
+	// 0でなければエピソード名をこの文字数(ルーン数)までに切り詰める。DynamoDBには元の名前を書く
+	MaxNameRunes int
+	// JSONのフィールド名。空ならspotify
+	FieldNames string
+	// JSONLで、既にあるファイルに書かれていないエピソードだけを追記する。
+	// 中断したエクスポートを最初からやり直さずに続ける
+	Append bool
+}
+
+// camelCaseのフィールド名で書き出すエピソード。
+// SpotifyのレスポンスはItemで読み、出力の形はこちらで決める
+type ExportItem struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	ReleaseDate        string   `json:"releaseDate"`
+	DurationMs         int64    `json:"durationMs"`
+	Explicit           bool     `json:"explicit"`
+	IsPlayable         bool     `json:"isPlayable"`
+	IsExternallyHosted bool     `json:"isExternallyHosted"`
+	Language           string   `json:"language,omitempty"`
+	Languages          []string `json:"languages,omitempty"`
+	Popularity         *int     `json:"popularity,omitempty"`
+	SpotifyURL         string   `json:"spotifyUrl"`
+	AudioPreviewURL    string   `json:"audioPreviewUrl,omitempty"`
+	URI                string   `json:"uri"`
+	ShowID             string   `json:"showId,omitempty"`
+	ImageURL           string   `json:"imageUrl,omitempty"`
+	ImageURLs          []string `json:"imageUrls,omitempty"`
+}
+
+func newExportItem(e exportedItem) ExportItem {
+	return ExportItem{
+		ID:                 e.ID,
+		Name:               e.Name,
+		Description:        e.Description,
+		ReleaseDate:        e.ReleaseDate,
+		DurationMs:         e.DurationMs,
+		Explicit:           e.Explicit,
+		IsPlayable:         e.IsPlayable,
+		IsExternallyHosted: e.IsExternallyHosted,
+		Language:           e.Language,
+		Languages:          e.Languages,
+		Popularity:         e.Popularity,
+		SpotifyURL:         e.SpotifyURL(),
+		AudioPreviewURL:    e.AudioPreviewURL,
+		URI:                e.URI,
+		ShowID:             e.ShowID,
+		ImageURL:           e.ImageURL,
+		ImageURLs:          e.ImageURLs,
+	}
+}
+
+// nルーンを超える名前を末尾に…を付けてnルーンにする。日本語も1文字を1と数える
+func truncateName(name string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(name) <= n {
+		return name
+	}
+	runes := []rune(name)
+	return string(runes[:n-1]) + "…"
+}
+
+// JSONに画像URLを加えたエピソード。画像の無いエピソードでは省く
+type exportedItem struct {
+	Item
+	ImageURL  string   `json:"image_url,omitempty"`
+	ImageURLs []string `json:"image_urls,omitempty"`
+}
+
+// 面積の最も大きい画像。同じ大きさなら先のもの
+func largestImage(images []Image) (Image, bool) {
+	if len(images) == 0 {
+		return Image{}, false
+	}
+	largest := images[0]
+	for _, image := range images[1:] {
+		if image.Width*image.Height > largest.Width*largest.Height {
+			largest = image
+		}
+	}
+	return largest, true
+}
+
+// 出力する画像URL。画像が無ければnil
+func exportImageURLs(item Item, mode string) []string {
+	if mode == ExportImagesLargest {
+		if image, ok := largestImage(item.Images); ok {
+			return []string{image.URL}
+		}
+		return nil
+	}
+
+	var urls []string
+	for _, image := range item.Images {
+		urls = append(urls, image.URL)
+	}
+	return urls
+}
+
+// 拡張子から出力形式を決める。.csvと.jsonl以外はJSON
+func ExportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ExportCSV
+	case ".jsonl":
+		return ExportJSONL
+	}
+	return ExportJSON
+}
+
+// JSONで書くエピソード。呼び出し元のエピソードは書き換えない
+func jsonRecord(item Item, options ExportOptions) any {
+	exported := exportedItem{Item: item}
+	exported.Name = truncateName(item.Name, options.MaxNameRunes)
+	if options.Images != "" {
+		urls := exportImageURLs(item, options.Images)
+		if options.Images == ExportImagesLargest && len(urls) > 0 {
+			exported.ImageURL = urls[0]
+		} else {
+			exported.ImageURLs = urls
+		}
+	}
+
+	if options.FieldNames == ExportFieldsCamel {
+		return newExportItem(exported)
+	}
+	return exported
+}
+
+// 中断したJSONLのエクスポートで書き終えていたエピソードIDと、完全な行までのバイト数。
+// 最後の行が途中で切れていればその行は書き直す
+func exportedJSONL(path string) (map[string]bool, int64, error) {
+	ids := make(map[string]bool)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ids, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record struct {
+			ID string `json:"id"`
+		}
+		err := json.Unmarshal(line, &record)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid line in %s: %w", path, err)
+		}
+		ids[record.ID] = true
+	}
+
+	return ids, int64(len(data)), nil
+}
+
+// 番組ごとの出力先。拡張子の前に番組名と番組IDを入れる。
+// 番組名が分からなければ番組IDだけ、番組IDも無ければunknownにする
+func showExportPath(path, showID, name string) string {
+	label := showID
+	if label == "" {
+		label = "unknown"
+	}
+	if slug := slugify(name); slug != "" {
+		label = slug + "-" + label
+	}
+
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + label + ext
+}
+
+// 番組ごとに別のファイルに書き出し、書いたファイルを番組の現れた順に返す。
+// namesは番組ID -> 番組名
+func ExportItemsByShow(items []Item, format, path string, options ExportOptions, names map[string]string) ([]string, error) {
+	var shows []string
+	byShow := make(map[string][]Item)
+	for _, item := range items {
+		if _, ok := byShow[item.ShowID]; !ok {
+			shows = append(shows, item.ShowID)
+		}
+		byShow[item.ShowID] = append(byShow[item.ShowID], item)
+	}
+
+	var paths []string
+	for _, showID := range shows {
+		showPath := showExportPath(path, showID, names[showID])
+		err := ExportItems(byShow[showID], format, showPath, options)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, showPath)
+	}
+	return paths, nil
+}
+
+// 出力の設定を確かめる
+func (o ExportOptions) validate(format string) error {
+	switch format {
+	case ExportJSON, ExportJSONL, ExportCSV:
+	default:
+		return fmt.Errorf("unknown export format %q (want %s, %s or %s)", format, ExportJSON, ExportJSONL, ExportCSV)
+	}
+	switch o.Images {
+	case "", ExportImagesLargest, ExportImagesAll:
+	default:
+		return fmt.Errorf("unknown export images %q (want %s or %s)", o.Images, ExportImagesLargest, ExportImagesAll)
+	}
+	switch o.FieldNames {
+	case "", ExportFieldsSpotify, ExportFieldsCamel:
+	default:
+		return fmt.Errorf("unknown export field names %q (want %s or %s)", o.FieldNames, ExportFieldsSpotify, ExportFieldsCamel)
+	}
+	if o.MaxNameRunes < 0 {
+		return fmt.Errorf("export name length must not be negative")
+	}
+	if o.Append && format != ExportJSONL {
+		return fmt.Errorf("only %s exports can be appended to", ExportJSONL)
+	}
+	return nil
+}
+
+// エピソードをファイルに書き出す。spotifyのフィールド名のJSONは -import でそのまま読み込める
+func ExportItems(items []Item, format, path string, options ExportOptions) error {
+	err := options.validate(format)
+	if err != nil {
+		return err
+	}
+
+	// 追記するなら書き終えていた行の後ろから書く
+	var exported map[string]bool
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var size int64
+	if options.Append {
+		exported, size, err = exportedJSONL(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		flag = os.O_WRONLY | os.O_CREATE
+	}
+
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if options.Append {
+		err = file.Truncate(size)
+		if err == nil {
+			_, err = file.Seek(size, io.SeekStart)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resume %s: %w", path, err)
+		}
+		if len(exported) > 0 {
+			slog.Info("resuming export", "file", path, "exported", len(exported))
+		}
+	}
+
+	err = writeExport(file, items, format, options, exported)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return file.Close()
+}
+
+// エピソードをformatでwに書く。JSONLではexportedにあるエピソードを飛ばす
+func writeExport(w io.Writer, items []Item, format string, options ExportOptions, exported map[string]bool) error {
+	var err error
+	switch format {
+	case ExportJSON:
+		// 直接書き、全体をメモリ上に組み立てない
+		encoder := json.NewEncoder(w)
+		if !options.Compact {
+			encoder.SetIndent("", "    ")
+		}
+		records := make([]any, len(items))
+		for i, item := range items {
+			records[i] = jsonRecord(item, options)
+		}
+		err = encoder.Encode(records)
+	case ExportJSONL:
+		// 1行に1エピソード。行ごとに書くので中断しても書き終えた行は残る
+		encoder := json.NewEncoder(w)
+		for _, item := range items {
+			if exported[item.ID] {
+				continue
+			}
+			err = encoder.Encode(jsonRecord(item, options))
+			if err != nil {
+				break
+			}
+		}
+	case ExportCSV:
+		// カンマや引用符を含む値はencoding/csvがエスケープする
+		writer := csv.NewWriter(w)
+		header := exportCSVHeader
+		switch options.Images {
+		case ExportImagesLargest:
+			header = append(slices.Clip(header), "ImageURL")
+		case ExportImagesAll:
+			header = append(slices.Clip(header), "ImageURLs")
+		}
+		writer.Write(header)
+		for _, item := range items {
+			record := []string{
+				truncateName(item.Name, options.MaxNameRunes),
+				item.ID,
+				item.ReleaseDate,
+				strconv.FormatInt(item.DurationMs, 10),
+				strconv.FormatBool(item.Explicit),
+				item.SpotifyURL(),
+			}
+			// 複数の画像URLは空白で区切る
+			if options.Images != "" {
+				record = append(record, strings.Join(exportImageURLs(item, options.Images), " "))
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		err = writer.Error()
+	}
+	return err
+}
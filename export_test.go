@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func exportTestItems() []Item {
+	items := []Item{
+		{ID: "ep1", Name: `Hello, "World"`, ReleaseDate: "2024-01-02", DurationMs: 60000, Explicit: true},
+		{ID: "ep2", Name: "Plain", ReleaseDate: "2024-01-01", DurationMs: 120000},
+	}
+	items[0].ExternalUrls.Spotify = "https://open.spotify.com/episode/ep1"
+	return items
+}
+
+func TestExportJSON(t *testing.T) {
+	items := exportTestItems()
+	path := filepath.Join(t.TempDir(), "episodes.json")
+
+	err := ExportItems(items, ExportFormat(path), path, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "[\n    {") {
+		t.Errorf("JSON is not indented:\n%s", data)
+	}
+	imported, err := ImportItems(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, items) {
+		t.Errorf("imported %+v, want %+v", imported, items)
+	}
+}
+
+func TestExportCompactJSON(t *testing.T) {
+	dir := t.TempDir()
+	pretty := filepath.Join(dir, "pretty.json")
+	compact := filepath.Join(dir, "compact.json")
+
+	err := ExportItems(exportTestItems(), ExportJSON, pretty, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ExportItems(exportTestItems(), ExportJSON, compact, ExportOptions{Compact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 末尾の改行はEncoderが付ける区切り
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	var want bytes.Buffer
+	err = json.Compact(&want, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want.Bytes()) {
+		t.Errorf("compact JSON has extra whitespace:\n%s", data)
+	}
+
+	prettyItems, err := ImportItems(pretty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compactItems, err := ImportItems(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(compactItems, prettyItems) {
+		t.Errorf("compact JSON decoded to %+v, want %+v", compactItems, prettyItems)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "episodes.CSV")
+
+	err := ExportItems(exportTestItems(), ExportFormat(path), path, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Hello, ""World"""`) {
+		t.Errorf("name with a comma and quotes is not escaped:\n%s", data)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Name", "ID", "ReleaseDate", "DurationMs", "Explicit", "SpotifyURL"},
+		{`Hello, "World"`, "ep1", "2024-01-02", "60000", "true", "https://open.spotify.com/episode/ep1"},
+		{"Plain", "ep2", "2024-01-01", "120000", "false", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+}
+
+func TestExportImages(t *testing.T) {
+	items := exportTestItems()
+	items[0].Images = []Image{
+		{Height: 64, URL: "https://i.scdn.co/image/small", Width: 64},
+		{Height: 640, URL: "https://i.scdn.co/image/large", Width: 640},
+		{Height: 300, URL: "https://i.scdn.co/image/medium", Width: 300},
+	}
+	// items[1]には画像が無い
+
+	t.Run("largest", func(t *testing.T) {
+		dir := t.TempDir()
+		err := ExportItems(items, ExportCSV, filepath.Join(dir, "episodes.csv"), ExportOptions{Images: ExportImagesLargest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := readCSV(t, filepath.Join(dir, "episodes.csv"))
+		if got := records[0][len(records[0])-1]; got != "ImageURL" {
+			t.Errorf("last column = %q, want ImageURL", got)
+		}
+		if got := records[1][len(records[1])-1]; got != "https://i.scdn.co/image/large" {
+			t.Errorf("image URL = %q, want the largest image", got)
+		}
+		if got := records[2][len(records[2])-1]; got != "" {
+			t.Errorf("image URL of an episode without images = %q, want empty", got)
+		}
+
+		err = ExportItems(items, ExportJSON, filepath.Join(dir, "episodes.json"), ExportOptions{Images: ExportImagesLargest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exported []map[string]any
+		readJSON(t, filepath.Join(dir, "episodes.json"), &exported)
+		if got := exported[0]["image_url"]; got != "https://i.scdn.co/image/large" {
+			t.Errorf("image_url = %v, want the largest image", got)
+		}
+		if _, ok := exported[1]["image_url"]; ok {
+			t.Errorf("episode without images has image_url %v", exported[1]["image_url"])
+		}
+	})
+
+	t.Run("all", func(t *testing.T) {
+		dir := t.TempDir()
+		err := ExportItems(items, ExportCSV, filepath.Join(dir, "episodes.csv"), ExportOptions{Images: ExportImagesAll})
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := readCSV(t, filepath.Join(dir, "episodes.csv"))
+		want := "https://i.scdn.co/image/small https://i.scdn.co/image/large https://i.scdn.co/image/medium"
+		if got := records[1][len(records[1])-1]; got != want {
+			t.Errorf("image URLs = %q, want %q", got, want)
+		}
+		if got := records[2][len(records[2])-1]; got != "" {
+			t.Errorf("image URLs of an episode without images = %q, want empty", got)
+		}
+
+		err = ExportItems(items, ExportJSON, filepath.Join(dir, "episodes.json"), ExportOptions{Images: ExportImagesAll})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exported []struct {
+			ImageURLs []string `json:"image_urls"`
+		}
+		readJSON(t, filepath.Join(dir, "episodes.json"), &exported)
+		if len(exported[0].ImageURLs) != 3 || len(exported[1].ImageURLs) != 0 {
+			t.Errorf("image_urls = %v, want all 3 images and none", exported)
+		}
+	})
+
+	if err := ExportItems(items, ExportCSV, filepath.Join(t.TempDir(), "episodes.csv"), ExportOptions{Images: "smallest"}); err == nil {
+		t.Error("unknown image option accepted")
+	}
+}
+
+func TestExportTruncatesNames(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"ポッドキャストの長いエピソードタイトル", 10, "ポッドキャストの長…"},
+		{"ポッドキャスト", 7, "ポッドキャスト"},
+		{"ポッドキャスト", 0, "ポッドキャスト"},
+		{"Hello, World", 6, "Hello…"},
+	}
+	for _, tt := range tests {
+		if got := truncateName(tt.name, tt.n); got != tt.want {
+			t.Errorf("truncateName(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+
+	items := []Item{{ID: "ep1", Name: "ポッドキャストの長いエピソードタイトル"}}
+	dir := t.TempDir()
+	options := ExportOptions{MaxNameRunes: 10}
+	err := ExportItems(items, ExportCSV, filepath.Join(dir, "episodes.csv"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ExportItems(items, ExportJSON, filepath.Join(dir, "episodes.json"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readCSV(t, filepath.Join(dir, "episodes.csv"))[1][0]; got != "ポッドキャストの長…" {
+		t.Errorf("CSV name = %q, want 10 runes with an ellipsis", got)
+	}
+	var exported []Item
+	readJSON(t, filepath.Join(dir, "episodes.json"), &exported)
+	if got := exported[0].Name; got != "ポッドキャストの長…" {
+		t.Errorf("JSON name = %q, want 10 runes with an ellipsis", got)
+	}
+	// 書き込むエピソードは元の名前のまま
+	if items[0].Name != "ポッドキャストの長いエピソードタイトル" {
+		t.Errorf("episode name changed to %q", items[0].Name)
+	}
+}
+
+func TestExportCamelCaseFieldNames(t *testing.T) {
+	// Spotifyのレスポンスはsnake_caseのまま読む
+	var item Item
+	err := json.Unmarshal([]byte(`{"id": "ep1", "name": "Episode 1", "release_date": "2024-01-02", "duration_ms": 60000, "show_id": "show1", "external_urls": {"spotify": "https://open.spotify.com/episode/ep1"}}`), &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ReleaseDate != "2024-01-02" || item.DurationMs != 60000 || item.SpotifyURL() == "" {
+		t.Fatalf("parsed %+v, want the snake_case fields", item)
+	}
+
+	path := filepath.Join(t.TempDir(), "episodes.json")
+	err = ExportItems([]Item{item}, ExportJSON, path, ExportOptions{FieldNames: ExportFieldsCamel})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var exported []map[string]any
+	readJSON(t, path, &exported)
+	want := map[string]any{
+		"id":          "ep1",
+		"name":        "Episode 1",
+		"releaseDate": "2024-01-02",
+		"durationMs":  float64(60000),
+		"spotifyUrl":  "https://open.spotify.com/episode/ep1",
+		"showId":      "show1",
+	}
+	for key, value := range want {
+		if exported[0][key] != value {
+			t.Errorf("%s = %v, want %v", key, exported[0][key], value)
+		}
+	}
+	for _, key := range []string{"release_date", "duration_ms", "external_urls", "show_id"} {
+		if _, ok := exported[0][key]; ok {
+			t.Errorf("camelCase export has the Spotify field %s", key)
+		}
+	}
+}
+
+func TestExportJSONLAppendResumes(t *testing.T) {
+	items := testItems(5)
+	path := filepath.Join(t.TempDir(), "episodes.jsonl")
+	if ExportFormat(path) != ExportJSONL {
+		t.Fatalf("ExportFormat(%s) = %s, want %s", path, ExportFormat(path), ExportJSONL)
+	}
+
+	// 2件書いて3件目の途中で中断したファイル
+	err := ExportItems(items[:3], ExportJSONL, path, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, data[:len(data)-10], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ExportItems(items, ExportJSONL, path, ExportOptions{Append: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var item Item
+		err := json.Unmarshal([]byte(line), &item)
+		if err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		ids = append(ids, item.ID)
+	}
+	if want := itemIDs(items); !reflect.DeepEqual(ids, want) {
+		t.Errorf("exported IDs = %v, want %v", ids, want)
+	}
+
+	// 書き終えたファイルに追記しても何も増えない
+	err = ExportItems(items, ExportJSONL, path, ExportOptions{Append: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("appending to a complete export changed it:\n%s", again)
+	}
+
+	if err := ExportItems(items, ExportJSON, filepath.Join(t.TempDir(), "episodes.json"), ExportOptions{Append: true}); err == nil {
+		t.Error("appending to a JSON export accepted")
+	}
+}
+
+func TestFetchExportSplitByShow(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3).Info.Name = "ゆる言語学ラジオ"
+	spotify.addShow(testShowB, 2).Info.Name = "Tech Talk: Go!"
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	dir := t.TempDir()
+
+	code := runCommand(t, config, "fetch", "-show", testShowA+","+testShowB, "-export", filepath.Join(dir, "episodes.json"), "-split-by-show")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	for file, want := range map[string][]string{
+		"episodes-ゆる言語学ラジオ-" + testShowA + ".json":     {"1111-001", "1111-002", "1111-003"},
+		"episodes-tech-talk-go-" + testShowB + ".json": {"2222-001", "2222-002"},
+	} {
+		path := filepath.Join(dir, file)
+		items, err := ImportItems(path)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", file, err)
+			continue
+		}
+		got := itemIDs(items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s has %v, want %v", file, got, want)
+		}
+		if err := VerifyChecksum(path); err != nil {
+			t.Errorf("checksum of %s: %v", file, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "episodes.json")); !os.IsNotExist(err) {
+		t.Errorf("combined export was written: %v", err)
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if err := ExportItems(nil, "xml", filepath.Join(t.TempDir(), "episodes.xml"), ExportOptions{}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
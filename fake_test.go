@@ -0,0 +1,810 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// テストで使う番組ID。SpotifyのIDと同じ22文字
+const (
+	testShowA = "1111111111111111111111"
+	testShowB = "2222222222222222222222"
+	testShowC = "3333333333333333333333"
+)
+
+// テスト用のDynamoDB。dynamodbiface.DynamoDBAPIとして直接渡すほか、
+// serveでHTTPサーバーにしてConfig.Endpointに指定すればSDK経由でも使える
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+	// 呼ばれた操作(PutItem など)を順に記録する
+	calls []string
+	// nilでなければ各操作の前に呼び、エラーを返せばその操作を失敗させる
+	fail func(op string, input any) error
+	// nilでなければBatchWriteItemのリクエストのうち未処理として返すものを選ぶ
+	unprocessed func(tableName string, requests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest
+	// 0でなければScanの1ページの最大件数。DynamoDBの1MBの上限の代わりに使う
+	scanPageSize int
+}
+
+type fakeTable struct {
+	input *dynamodb.CreateTableInput
+	// 書き込まれた順のキー
+	keys  []string
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{tables: make(map[string]*fakeTable)}
+}
+
+// SDK経由で使うためのサーバーを起動してURLを返す
+func (f *fakeDynamoDB) serve(t *testing.T) string {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func (f *fakeDynamoDB) begin(op string, input any) error {
+	f.calls = append(f.calls, op)
+	if f.fail != nil {
+		return f.fail(op, input)
+	}
+	return nil
+}
+
+func (f *fakeDynamoDB) countCalls(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var n int
+	for _, call := range f.calls {
+		if call == op {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeDynamoDB) hasTable(tableName string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.tables[tableName]
+	return ok
+}
+
+// テーブルの項目を書き込まれた順に返す
+func (f *fakeDynamoDB) items(tableName string) []map[string]*dynamodb.AttributeValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	table, ok := f.tables[tableName]
+	if !ok {
+		return nil
+	}
+	var items []map[string]*dynamodb.AttributeValue
+	for _, key := range table.keys {
+		items = append(items, table.items[key])
+	}
+	return items
+}
+
+// テーブルの項目のIDを書き込まれた順に返す
+func (f *fakeDynamoDB) ids(tableName string) []string {
+	var ids []string
+	for _, item := range f.items(tableName) {
+		ids = append(ids, aws.StringValue(item["ID"].S))
+	}
+	return ids
+}
+
+// IDがキーのテーブルを作って項目を入れる
+func (f *fakeDynamoDB) seed(tableName string, items ...map[string]*dynamodb.AttributeValue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	table, ok := f.tables[tableName]
+	if !ok {
+		table = newFakeTable(&dynamodb.CreateTableInput{
+			TableName: aws.String(tableName),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String("ID"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			},
+		})
+		f.tables[tableName] = table
+	}
+	for _, item := range items {
+		table.put(item)
+	}
+}
+
+func newFakeTable(input *dynamodb.CreateTableInput) *fakeTable {
+	return &fakeTable{input: input, items: make(map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func (t *fakeTable) key(attributes map[string]*dynamodb.AttributeValue) (string, error) {
+	var parts []string
+	for _, element := range t.input.KeySchema {
+		name := aws.StringValue(element.AttributeName)
+		value := attributes[name]
+		if value == nil || value.S == nil && value.N == nil {
+			return "", awserr.New("ValidationException", "missing key attribute "+name, nil)
+		}
+		parts = append(parts, aws.StringValue(value.S)+aws.StringValue(value.N))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+func (t *fakeTable) put(item map[string]*dynamodb.AttributeValue) error {
+	key, err := t.key(item)
+	if err != nil {
+		return err
+	}
+	if _, ok := t.items[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.items[key] = item
+	return nil
+}
+
+func (t *fakeTable) delete(key map[string]*dynamodb.AttributeValue) error {
+	k, err := t.key(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := t.items[k]; !ok {
+		return nil
+	}
+	delete(t.items, k)
+	for i := range t.keys {
+		if t.keys[i] == k {
+			t.keys = append(t.keys[:i], t.keys[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeDynamoDB) table(tableName *string) (*fakeTable, error) {
+	table, ok := f.tables[aws.StringValue(tableName)]
+	if !ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found: "+aws.StringValue(tableName), nil)
+	}
+	return table, nil
+}
+
+func (f *fakeDynamoDB) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateTable", input); err != nil {
+		return nil, err
+	}
+
+	if _, ok := f.tables[aws.StringValue(input.TableName)]; ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, "Table already exists: "+aws.StringValue(input.TableName), nil)
+	}
+	f.tables[aws.StringValue(input.TableName)] = newFakeTable(input)
+
+	return &dynamodb.CreateTableOutput{TableDescription: describe(input, 0)}, nil
+}
+
+func describe(input *dynamodb.CreateTableInput, count int) *dynamodb.TableDescription {
+	return &dynamodb.TableDescription{
+		TableName:            input.TableName,
+		TableStatus:          aws.String(dynamodb.TableStatusActive),
+		KeySchema:            input.KeySchema,
+		AttributeDefinitions: input.AttributeDefinitions,
+		ItemCount:            aws.Int64(int64(count)),
+	}
+}
+
+func (f *fakeDynamoDB) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeTable", input); err != nil {
+		return nil, err
+	}
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.DescribeTableOutput{Table: describe(table.input, len(table.keys))}, nil
+}
+
+func (f *fakeDynamoDB) DeleteTableWithContext(ctx aws.Context, input *dynamodb.DeleteTableInput, opts ...request.Option) (*dynamodb.DeleteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteTable", input); err != nil {
+		return nil, err
+	}
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(f.tables, aws.StringValue(input.TableName))
+	return &dynamodb.DeleteTableOutput{TableDescription: describe(table.input, len(table.keys))}, nil
+}
+
+// 作成・削除はすぐに終わる
+func (f *fakeDynamoDB) WaitUntilTableExistsWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.WaiterOption) error {
+	return nil
+}
+
+func (f *fakeDynamoDB) WaitUntilTableNotExistsWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.WaiterOption) error {
+	return nil
+}
+
+func (f *fakeDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("PutItem", input); err != nil {
+		return nil, err
+	}
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := table.key(input.Item)
+	if err != nil {
+		return nil, err
+	}
+	old := table.items[key]
+
+	if input.ConditionExpression != nil && !evalCondition(*input.ConditionExpression, old, input.ExpressionAttributeValues) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	table.put(input.Item)
+
+	output := &dynamodb.PutItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = old
+	}
+	return output, nil
+}
+
+func (f *fakeDynamoDB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("BatchWriteItem", input); err != nil {
+		return nil, err
+	}
+
+	var tableNames []string
+	for tableName := range input.RequestItems {
+		if _, err := f.table(aws.String(tableName)); err != nil {
+			return nil, err
+		}
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	output := &dynamodb.BatchWriteItemOutput{}
+	for _, tableName := range tableNames {
+		table := f.tables[tableName]
+		requests := input.RequestItems[tableName]
+
+		var left []*dynamodb.WriteRequest
+		if f.unprocessed != nil {
+			left = f.unprocessed(tableName, requests)
+		}
+		for _, request := range requests {
+			if containsRequest(left, request) {
+				continue
+			}
+			var err error
+			if request.PutRequest != nil {
+				err = table.put(request.PutRequest.Item)
+			} else if request.DeleteRequest != nil {
+				err = table.delete(request.DeleteRequest.Key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(left) > 0 {
+			if output.UnprocessedItems == nil {
+				output.UnprocessedItems = make(map[string][]*dynamodb.WriteRequest)
+			}
+			output.UnprocessedItems[tableName] = left
+		}
+	}
+
+	return output, nil
+}
+
+func containsRequest(requests []*dynamodb.WriteRequest, request *dynamodb.WriteRequest) bool {
+	for _, r := range requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("GetItem", input); err != nil {
+		return nil, err
+	}
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := table.key(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.GetItemOutput{Item: table.items[key]}, nil
+}
+
+func (f *fakeDynamoDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("Scan", input); err != nil {
+		return nil, err
+	}
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if len(input.ExclusiveStartKey) > 0 {
+		key, err := table.key(input.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		for i := range table.keys {
+			if table.keys[i] == key {
+				start = i + 1
+			}
+		}
+	}
+
+	limit := f.scanPageSize
+	if input.Limit != nil && (limit == 0 || int(*input.Limit) < limit) {
+		limit = int(*input.Limit)
+	}
+
+	output := &dynamodb.ScanOutput{}
+	for _, key := range table.keys[start:] {
+		if limit > 0 && len(output.Items) == limit {
+			last := output.Items[len(output.Items)-1]
+			output.LastEvaluatedKey = make(map[string]*dynamodb.AttributeValue)
+			for _, element := range table.input.KeySchema {
+				name := aws.StringValue(element.AttributeName)
+				output.LastEvaluatedKey[name] = last[name]
+			}
+			break
+		}
+		output.Items = append(output.Items, table.items[key])
+	}
+	output.Count = aws.Int64(int64(len(output.Items)))
+
+	return output, nil
+}
+
+func (f *fakeDynamoDB) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+	page := *input
+	for {
+		output, err := f.ScanWithContext(ctx, &page)
+		if err != nil {
+			return err
+		}
+		lastPage := len(output.LastEvaluatedKey) == 0
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		page.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// attribute_exists/attribute_not_exists と比較をAND/ORでつないだ条件だけを扱う
+func evalCondition(expression string, item, values map[string]*dynamodb.AttributeValue) bool {
+	for _, or := range strings.Split(expression, " OR ") {
+		ok := true
+		for _, term := range strings.Split(or, " AND ") {
+			if !evalTerm(strings.TrimSpace(term), item, values) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func evalTerm(term string, item, values map[string]*dynamodb.AttributeValue) bool {
+	if name, ok := strings.CutPrefix(term, "attribute_exists("); ok {
+		return item[strings.TrimSuffix(name, ")")] != nil
+	}
+	if name, ok := strings.CutPrefix(term, "attribute_not_exists("); ok {
+		return item[strings.TrimSuffix(name, ")")] == nil
+	}
+
+	fields := strings.Fields(term)
+	if len(fields) != 3 {
+		panic("unsupported condition: " + term)
+	}
+	a, b := item[fields[0]], values[fields[2]]
+	if a == nil || b == nil {
+		return false
+	}
+
+	var cmp int
+	if a.N != nil && b.N != nil {
+		x, _ := strconv.ParseFloat(*a.N, 64)
+		y, _ := strconv.ParseFloat(*b.N, 64)
+		cmp = map[bool]int{true: -1, false: 1}[x < y]
+		if x == y {
+			cmp = 0
+		}
+	} else {
+		cmp = strings.Compare(aws.StringValue(a.S), aws.StringValue(b.S))
+	}
+
+	switch fields[1] {
+	case "<":
+		return cmp < 0
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	default:
+		panic("unsupported condition: " + term)
+	}
+}
+
+// DynamoDBのJSONプロトコル(X-Amz-Target: DynamoDB_20120810.<操作>)で受ける
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); op {
+	case "CreateTable":
+		serveOp(w, r, f.CreateTableWithContext)
+	case "DescribeTable":
+		serveOp(w, r, f.DescribeTableWithContext)
+	case "DeleteTable":
+		serveOp(w, r, f.DeleteTableWithContext)
+	case "PutItem":
+		serveOp(w, r, f.PutItemWithContext)
+	case "BatchWriteItem":
+		serveOp(w, r, f.BatchWriteItemWithContext)
+	case "GetItem":
+		serveOp(w, r, f.GetItemWithContext)
+	case "Scan":
+		serveOp(w, r, f.ScanWithContext)
+	default:
+		writeAWSError(w, awserr.New("UnknownOperationException", "unsupported operation "+op, nil))
+	}
+}
+
+func serveOp[In, Out any](w http.ResponseWriter, r *http.Request, op func(aws.Context, *In, ...request.Option) (*Out, error)) {
+	var input In
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		writeAWSError(w, awserr.New("SerializationException", err.Error(), nil))
+		return
+	}
+
+	output, err := op(r.Context(), &input)
+	if err != nil {
+		writeAWSError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	json.NewEncoder(w).Encode(output)
+}
+
+func writeAWSError(w http.ResponseWriter, err error) {
+	code := "InternalFailure"
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		code = aerr.Code()
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"__type":  "com.amazonaws.dynamodb.v20120810#" + code,
+		"message": err.Error(),
+	})
+}
+
+type fakeShow struct {
+	Info     ProgramInfo
+	Episodes []Item
+}
+
+type fakeRequest struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+}
+
+// テスト用のSpotify。/token でトークンを返し、/v1/shows/{id} と
+// /v1/shows/{id}/episodes でエピソードをページに分けて返す
+type fakeSpotify struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	shows map[string]*fakeShow
+	// 番組情報に含める1ページ目のエピソード数
+	firstPage int
+	// トークンの有効期間(秒)
+	expiresIn  int
+	tokenCalls int
+	requests   []fakeRequest
+	// nilでなければ通常の処理の前に呼び、trueを返せば応答済みとみなす
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+}
+
+// 起動してspotifyAPIをこのサーバーに向ける。テストの終わりに元に戻す
+func newFakeSpotify(t *testing.T) *fakeSpotify {
+	f := &fakeSpotify{
+		shows:     make(map[string]*fakeShow),
+		firstPage: 2,
+		expiresIn: 3600,
+	}
+	f.Server = httptest.NewServer(f)
+	t.Cleanup(f.Close)
+
+	api := spotifyAPI
+	spotifyAPI = f.URL + "/v1"
+	t.Cleanup(func() { spotifyAPI = api })
+
+	return f
+}
+
+// n件のエピソードがある番組を追加する。エピソードは新しい順に並ぶ
+func (f *fakeSpotify) addShow(id string, n int) *fakeShow {
+	show := &fakeShow{Info: ProgramInfo{ID: id, Name: "Show " + id[:4], Publisher: "Publisher"}}
+	for i := n; i >= 1; i-- {
+		show.Episodes = append(show.Episodes, Item{
+			ID:                   fmt.Sprintf("%s-%03d", id[:4], i),
+			Name:                 fmt.Sprintf("Episode %d", i),
+			Description:          fmt.Sprintf("Description %d", i),
+			DurationMs:           int64(i) * 60000,
+			ReleaseDate:          fmt.Sprintf("2024-01-%02d", (i-1)%28+1),
+			ReleaseDatePrecision: "day",
+			IsPlayable:           true,
+		})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shows[id] = show
+	return show
+}
+
+func (f *fakeSpotify) tokenCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tokenCalls
+}
+
+// パスに一致したリクエスト
+func (f *fakeSpotify) requestsTo(path string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var requests []fakeRequest
+	for _, r := range f.requests {
+		if r.URL.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func (f *fakeSpotify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{Method: r.Method, URL: r.URL, Header: r.Header.Clone()})
+	intercept := f.intercept
+	f.mu.Unlock()
+
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+
+	if r.URL.Path == "/token" {
+		f.mu.Lock()
+		f.tokenCalls++
+		n := f.tokenCalls
+		f.mu.Unlock()
+
+		writeJSON(w, TokenResponse{AccessToken: fmt.Sprintf("token-%d", n), TokenType: "Bearer", ExpiresIn: f.expiresIn})
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/shows/"), "/")
+	f.mu.Lock()
+	show, ok := f.shows[id]
+	f.mu.Unlock()
+	if !strings.HasPrefix(r.URL.Path, "/v1/shows/") || !ok {
+		http.Error(w, `{"error":{"status":404,"message":"Non existing id"}}`, http.StatusNotFound)
+		return
+	}
+
+	switch rest {
+	case "":
+		info := show.Info
+		if info.TotalEpisodes == 0 {
+			info.TotalEpisodes = len(show.Episodes)
+		}
+		info.Episodes = f.page(show, 0, f.firstPage)
+		writeJSON(w, info)
+	case "episodes":
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 {
+			limit = 20
+		}
+		writeJSON(w, f.page(show, offset, limit))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeSpotify) page(show *fakeShow, offset, limit int) EpisodePage {
+	end := min(offset+limit, len(show.Episodes))
+	page := EpisodePage{
+		Href:   fmt.Sprintf("%s/v1/shows/%s/episodes?offset=%d&limit=%d", f.URL, show.Info.ID, offset, limit),
+		Items:  append([]Item{}, show.Episodes[min(offset, end):end]...),
+		Limit:  limit,
+		Offset: offset,
+		Total:  len(show.Episodes),
+	}
+	if end < len(show.Episodes) {
+		page.Next = fmt.Sprintf("%s/v1/shows/%s/episodes?offset=%d&limit=%d", f.URL, show.Info.ID, end, limit)
+	}
+	return page
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// 偽のSpotifyとDynamoDBに接続する設定。トークンはディスクに保存しない
+func testConfig(spotify *fakeSpotify, dynamoEndpoint string) Config {
+	config := Config{
+		ClientID:         "client",
+		ClientSecret:     "secret",
+		Region:           "us-west-2",
+		Endpoint:         dynamoEndpoint,
+		TokenCacheFile:   "-",
+		MaxRetries:       1,
+		RetryBaseDelayMs: 1,
+	}
+	if spotify != nil {
+		config.TokenURL = spotify.URL + "/token"
+	}
+	return config
+}
+
+func testTokenManager(spotify *fakeSpotify, config Config) *TokenManager {
+	return NewTokenManager(spotify.Client(), config)
+}
+
+// configをconfig.jsonに書いた一時ディレクトリでコマンドを実行し、終了コードを返す
+func runCommand(t *testing.T, config Config, args ...string) int {
+	t.Helper()
+
+	dir := t.TempDir()
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "config.json"), data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	return run(args)
+}
+
+// 受け取ったRunStatsを残すReportSink
+type capturingSink struct {
+	mu    sync.Mutex
+	stats []RunStats
+}
+
+func (s *capturingSink) Report(ctx context.Context, stats RunStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = append(s.stats, stats)
+	return nil
+}
+
+// -report nameで選べるように登録する。テストの終わりに登録を消す
+func registerCapturingSink(t *testing.T, name string) *capturingSink {
+	sink := &capturingSink{}
+	RegisterReportSink(name, sink)
+	t.Cleanup(func() { delete(reportSinks, name) })
+	return sink
+}
+
+func (s *capturingSink) last(t *testing.T) RunStats {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stats) == 0 {
+		t.Fatal("no run stats were reported")
+	}
+	return s.stats[len(s.stats)-1]
+}
+
+// テストの間のspanをメモリに記録する。終わったらtracerを元に戻す
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	original := tracer
+	tracer = provider.Tracer("podcast")
+	t.Cleanup(func() { tracer = original })
+
+	return exporter
+}
+
+// 記録されたspanのうち名前が一致するもの
+func spansNamed(exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStubs {
+	var spans tracetest.SpanStubs
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func spanInt(span tracetest.SpanStub, key string) int64 {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.AsInt64()
+		}
+	}
+	return -1
+}
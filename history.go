@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const defaultHistoryTableName = "ProgramHistory"
+
+// 総エピソード数の推移を書くテーブル
+func (c Config) historyTableName() string {
+	if c.HistoryTableName == "" {
+		return defaultHistoryTableName
+	}
+	return c.HistoryTableName
+}
+
+// 実行ごとの総エピソード数を記録する。ShowIDとRecordedAtがキー
+func PutHistory(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, showID string, totalEpisodes int, recordedAt time.Time) error {
+	err := ensureTable(ctx, svc, &dynamodb.CreateTableInput{
+		TableName: aws.String(config.historyTableName()),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("ShowID"),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+			{
+				AttributeName: aws.String("RecordedAt"),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("ShowID"),
+				KeyType:       aws.String(dynamodb.KeyTypeHash),
+			},
+			{
+				AttributeName: aws.String("RecordedAt"),
+				KeyType:       aws.String(dynamodb.KeyTypeRange),
+			},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(config.historyTableName()),
+		Item: map[string]*dynamodb.AttributeValue{
+			"ShowID": {
+				S: aws.String(showID),
+			},
+			"RecordedAt": {
+				S: aws.String(recordedAt.UTC().Format(time.RFC3339)),
+			},
+			"TotalEpisodes": {
+				N: aws.String(strconv.Itoa(totalEpisodes)),
+			},
+		},
+	})
+
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestPutHistoryKeepsEachRun(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	config := Config{}
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	err := PutHistory(context.Background(), dynamo, config, testShowA, 10, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PutHistory(context.Background(), dynamo, config, testShowA, 12, first.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := dynamo.items(defaultHistoryTableName)
+	if len(items) != 2 {
+		t.Fatalf("%d history points, want 2", len(items))
+	}
+	for i, want := range []struct{ recordedAt, total string }{
+		{"2024-01-01T09:00:00Z", "10"},
+		{"2024-01-02T09:00:00Z", "12"},
+	} {
+		item := items[i]
+		if aws.StringValue(item["ShowID"].S) != testShowA || aws.StringValue(item["RecordedAt"].S) != want.recordedAt || aws.StringValue(item["TotalEpisodes"].N) != want.total {
+			t.Errorf("history point %d = %v, want %s at %s", i, item, want.total, want.recordedAt)
+		}
+	}
+}
+
+func TestPutHistoryCustomTableName(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	config := Config{HistoryTableName: "DevHistory"}
+
+	err := PutHistory(context.Background(), dynamo, config, testShowA, 10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(dynamo.items("DevHistory")); n != 1 {
+		t.Errorf("%d history points in DevHistory, want 1", n)
+	}
+	if dynamo.hasTable(defaultHistoryTableName) {
+		t.Errorf("default table %s was created", defaultHistoryTableName)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var keyNormalizers = map[string]func(string) string{
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"slug":  slugify,
+}
+
+// 英数字以外を "-" にまとめる。日本語などの文字はそのまま残す
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func (c Config) ValidateKeyNormalization() error {
+	for _, step := range c.KeyNormalization {
+		if _, ok := keyNormalizers[step]; !ok {
+			return fmt.Errorf("unknown key normalization %q", step)
+		}
+	}
+	if c.KeyNumberWidth < 0 {
+		return fmt.Errorf("key_number_width must not be negative")
+	}
+
+	return nil
+}
+
+// "#2" と "#10" が文字列順でも正しく並ぶよう、キー中の数字を0埋めする
+func padNumbers(key string, width int) string {
+	var b strings.Builder
+	var digits []rune
+	flush := func() {
+		for i := len(digits); i < width; i++ {
+			b.WriteRune('0')
+		}
+		b.WriteString(string(digits))
+		digits = digits[:0]
+	}
+
+	for _, r := range key {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+			continue
+		}
+		if len(digits) > 0 {
+			flush()
+		}
+		b.WriteRune(r)
+	}
+	if len(digits) > 0 {
+		flush()
+	}
+
+	return b.String()
+}
+
+func NormalizeKey(key string, steps []string) string {
+	for _, step := range steps {
+		key = keyNormalizers[step](key)
+	}
+
+	return key
+}
+
+// 名前を正規化・0埋めした並べ替え用のキーをSortKey属性に書くか
+func (c Config) usesSortKey() bool {
+	return len(c.KeyNormalization) > 0 || c.KeyNumberWidth > 0
+}
+
+// 並べ替え用のキー。正規化と数字の0埋めをする
+func EpisodeKey(config Config, name string) string {
+	key := NormalizeKey(name, config.KeyNormalization)
+	if config.KeyNumberWidth > 0 {
+		key = padNumbers(key, config.KeyNumberWidth)
+	}
+
+	return key
+}
+
+// IDが同じエピソードは最初のものだけ残す。名前が同じでも別のエピソードなら両方残す
+func DedupByID(items []Item) ([]Item, int) {
+	seen := make(map[string]bool)
+	var kept []Item
+	for _, item := range items {
+		if seen[item.ID] {
+			continue
+		}
+		seen[item.ID] = true
+		kept = append(kept, item)
+	}
+
+	return kept, len(items) - len(kept)
+}
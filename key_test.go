@@ -0,0 +1,157 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestDedupByID(t *testing.T) {
+	items := []Item{
+		{ID: "a", Name: "Q&A"},
+		{ID: "b", Name: "Q&A"},
+		{ID: "a", Name: "Q&A (repeat)"},
+	}
+
+	kept, duplicates := DedupByID(items)
+
+	if duplicates != 1 {
+		t.Errorf("duplicates = %d, want 1", duplicates)
+	}
+	want := []Item{{ID: "a", Name: "Q&A"}, {ID: "b", Name: "Q&A"}}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept = %+v, want %+v", kept, want)
+	}
+}
+
+func TestItemToAttributesSortKey(t *testing.T) {
+	item := Item{ID: "a", Name: "Q&A #2"}
+
+	attributes := itemToAttributes(Config{}, item)
+	if _, ok := attributes["SortKey"]; ok {
+		t.Error("SortKey written without key normalization")
+	}
+
+	config := Config{KeyNormalization: []string{"slug"}, KeyNumberWidth: 3}
+	attributes = itemToAttributes(config, item)
+	if name := aws.StringValue(attributes["Name"].S); name != "Q&A #2" {
+		t.Errorf("Name = %q, want the original name", name)
+	}
+	if key := aws.StringValue(attributes["SortKey"].S); key != "q-a-002" {
+		t.Errorf("SortKey = %q, want q-a-002", key)
+	}
+}
+
+func TestSortKeyOrder(t *testing.T) {
+	config := Config{KeyNumberWidth: 3}
+	items := []Item{
+		{ID: "c", Name: "Episode 100", ShowID: testShowA},
+		{ID: "a", Name: "Episode 2", ShowID: testShowA},
+		{ID: "b", Name: "Episode 10", ShowID: testShowA},
+	}
+
+	var keys []string
+	byKey := make(map[string]string)
+	for _, item := range items {
+		key := aws.StringValue(itemToAttributes(config, item)["SortKey"].S)
+		keys = append(keys, key)
+		byKey[key] = item.Name
+	}
+	// DynamoDBはSortKeyをバイト順に並べる
+	sort.Strings(keys)
+
+	var names []string
+	for _, key := range keys {
+		names = append(names, byKey[key])
+	}
+	want := []string{"Episode 2", "Episode 10", "Episode 100"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("order by SortKey = %v, want %v", names, want)
+	}
+}
+
+func TestEpisodeKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  []string
+		width  int
+		input  string
+		output string
+	}{
+		{"none", nil, 0, "  Ep 1: Hello ", "  Ep 1: Hello "},
+		{"trim", []string{"trim"}, 0, "  Ep 1 ", "Ep 1"},
+		{"lower", []string{"lower"}, 0, "Ep 1", "ep 1"},
+		{"slug", []string{"slug"}, 0, "Ep. 1: Hello, World!", "ep-1-hello-world"},
+		{"slug keeps japanese", []string{"slug"}, 0, "第1回 ゲスト", "第1回-ゲスト"},
+		{"trim then lower", []string{"trim", "lower"}, 0, " EP ", "ep"},
+		{"padding", nil, 3, "Ep 1 part 12", "Ep 001 part 012"},
+		{"padding keeps longer numbers", nil, 2, "Ep 1000", "Ep 1000"},
+		{"slug and padding", []string{"slug"}, 3, "Ep #7", "ep-007"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{KeyNormalization: tt.steps, KeyNumberWidth: tt.width}
+			if got := EpisodeKey(config, tt.input); got != tt.output {
+				t.Errorf("EpisodeKey(%q) = %q, want %q", tt.input, got, tt.output)
+			}
+		})
+	}
+}
+
+func TestValidateKeyNormalization(t *testing.T) {
+	if err := (Config{KeyNormalization: []string{"trim", "slug"}}).ValidateKeyNormalization(); err != nil {
+		t.Errorf("known steps rejected: %v", err)
+	}
+	if err := (Config{KeyNormalization: []string{"upper"}}).ValidateKeyNormalization(); err == nil {
+		t.Error("unknown step accepted")
+	}
+	if err := (Config{KeyNumberWidth: -1}).ValidateKeyNormalization(); err == nil {
+		t.Error("negative width accepted")
+	}
+}
+
+func TestNormalizedKeysDoNotDedup(t *testing.T) {
+	config := Config{KeyNormalization: []string{"slug"}, KeyNumberWidth: 2}
+	items := []Item{{ID: "a", Name: "Ep 1"}, {ID: "b", Name: "Ep 01"}}
+
+	if EpisodeKey(config, items[0].Name) != EpisodeKey(config, items[1].Name) {
+		t.Fatal("test episodes should share a key")
+	}
+	kept, _ := DedupByID(items)
+	if len(kept) != 2 {
+		t.Errorf("kept %d episodes, want 2", len(kept))
+	}
+}
+
+// 正規化後のキーが同じでもIDが違えば別のエピソードとして書く
+func TestFetchKeepsEpisodesWithSameKey(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	show := spotify.addShow(testShowA, 2)
+	for i := range show.Episodes {
+		show.Episodes[i].Name = "Q&A"
+	}
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.KeyNumberWidth = 3
+
+	code := runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	items := dynamo.items(config.tableName())
+	if len(items) != 2 {
+		t.Fatalf("stored %d episodes, want 2", len(items))
+	}
+	for _, item := range items {
+		if name := aws.StringValue(item["Name"].S); name != "Q&A" {
+			t.Errorf("Name = %q, want Q&A", name)
+		}
+		if item["SortKey"] == nil {
+			t.Errorf("episode %s has no SortKey", aws.StringValue(item["ID"].S))
+		}
+	}
+}
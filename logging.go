@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// ログの出力先。テストでは差し替える
+var logOutput io.Writer = os.Stderr
+
+// jsonの場合はlogパッケージの出力もslogのJSONハンドラー経由にする。
+// levelより低いslogのイベントは出力しない
+func SetupLogging(format, level string) error {
+	var logLevel slog.Level
+	err := logLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+	}
+
+	switch format {
+	case "text":
+		log.SetOutput(logOutput)
+		slog.SetLogLoggerLevel(logLevel)
+		return nil
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, &slog.HandlerOptions{Level: logLevel})))
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+}
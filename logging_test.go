@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 複数のgoroutineから書かれても壊れないバッファ
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// テストの間のログを捕まえる。終わったら既定の出力に戻す
+func captureLogs(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	logger := slog.Default()
+	logOutput = buf
+	log.SetOutput(buf)
+	t.Cleanup(func() {
+		logOutput = os.Stderr
+		slog.SetDefault(logger)
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	return buf
+}
+
+// JSON形式のログからslogのイベントだけを取り出す
+func slogRecords(t *testing.T, logs string) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		var record map[string]any
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatalf("log line is not a JSON object: %q", line)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func findRecord(records []map[string]any, level, msg string) map[string]any {
+	for _, record := range records {
+		if record["level"] == level && record["msg"] == msg {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestExitCodeLogsWithSlog(t *testing.T) {
+	logs := captureLogs(t)
+	quietErrors = false
+	defer func() { quietErrors = false }()
+
+	err := SetupLogging("json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Info("below the level")
+	exitCode("fetch", errors.New("failed to fetch show"))
+	quietErrors = true
+	exitCode("search", errors.New("failed to search shows"))
+
+	records := slogRecords(t, logs.String())
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2: %v", len(records), records)
+	}
+	if record := findRecord(records, "ERROR", "fetch failed"); record == nil || record["error"] != "failed to fetch show" {
+		t.Errorf("no ERROR record for the failed command: %v", records)
+	}
+	if record := findRecord(records, "WARN", "search failed"); record == nil || record["error"] != "failed to search shows" {
+		t.Errorf("no WARN record for the failed command with -quiet-errors: %v", records)
+	}
+}
+
+func TestSetupLoggingJSON(t *testing.T) {
+	logs := captureLogs(t)
+
+	err := SetupLogging("json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Printf("Wrote %d episodes", 3)
+	slog.Info("items written", "count", 3)
+	slog.Debug("not shown")
+
+	records := slogRecords(t, logs.String())
+	if len(records) != 2 {
+		t.Fatalf("%d log lines, want 2:\n%s", len(records), logs.String())
+	}
+	if records[0]["msg"] != "Wrote 3 episodes" || records[0]["level"] != "INFO" {
+		t.Errorf("log package line = %v", records[0])
+	}
+	if records[1]["msg"] != "items written" || records[1]["count"] != float64(3) {
+		t.Errorf("slog line = %v", records[1])
+	}
+}
+
+func TestSetupLoggingInvalid(t *testing.T) {
+	captureLogs(t)
+
+	if err := SetupLogging("xml", "info"); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if err := SetupLogging("text", "verbose"); err == nil {
+		t.Error("unknown level accepted")
+	}
+}
+
+func TestFetchLogsWarningsAndErrorsWithSlog(t *testing.T) {
+	logs := captureLogs(t)
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	runCommand(t, config, "fetch", "-log-format", "json", "-explain", "-show", testShowA+","+testShowB+",not-a-show")
+
+	records := slogRecords(t, logs.String())
+	if record := findRecord(records, "WARN", "skipping invalid show"); record == nil || record["show"] != "not-a-show" {
+		t.Errorf("no WARN record for the invalid show: %v", record)
+	}
+	if record := findRecord(records, "ERROR", "failed to fetch show, skipping"); record == nil || record["show"] != testShowB {
+		t.Errorf("no ERROR record for the failed show: %v", record)
+	}
+	if record := findRecord(records, "INFO", "explain"); record == nil || record["show"] != testShowA || record["decision"] == nil {
+		t.Errorf("no INFO record for -explain: %v", record)
+	}
+}
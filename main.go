@@ -2,24 +2,242 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/microcosm-cc/bluemonday"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/net/http/httpguts"
 )
 
 type Config struct {
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 	TokenURL     string `json:"token_url"`
+
+	// DynamoDB。EndpointがあればDynamoDB Local、無ければRegionのAWSに接続する
+	Region   string `json:"region"`
+	Endpoint string `json:"endpoint"`
+
+	ExtraAttributes map[string]string `json:"extra_attributes"`
+	OTLPEndpoint    string            `json:"otlp_endpoint"`
+
+	ShowIDs   []string `json:"show_ids"`
+	TableName string   `json:"table_name"`
+
+	KeyNormalization []string `json:"key_normalization"`
+	KeyNumberWidth   int      `json:"key_number_width"`
+	StateFile        string   `json:"state_file"`
+	SplitByLanguage  bool     `json:"split_by_language"`
+
+	StoreSafeHTMLDescription bool `json:"store_safe_html_description"`
+
+	// TotalEpisodesに対して取得できた割合(%)がこれ未満なら書き込まない
+	MinCompletenessPercent float64 `json:"min_completeness_percent"`
+
+	ExtraHeaders map[string]string `json:"extra_headers"`
+
+	// 保存済みのLastUpdatedの方が新しい場合は上書きしない
+	NoClobber bool `json:"no_clobber"`
+
+	RecordHistory bool `json:"record_history"`
+	// 未設定なら "ProgramHistory"
+	HistoryTableName string `json:"history_table_name"`
+	// 番組の情報(出版者、説明、総エピソード数、画像など)をShowテーブルに記録する
+	RecordShows bool `json:"record_shows"`
+	// 未設定なら "Show"
+	ShowTableName string `json:"show_table_name"`
+
+	NotifyType       string `json:"notify_type"`
+	NotifyWebhookURL string `json:"notify_webhook_url"`
+
+	// 属性名 -> Itemのフィールドのパス。指定した場合はこの属性だけを書く
+	AttributeMapping map[string]string `json:"attribute_mapping"`
+
+	// レスポンスボディの上限(バイト)。0なら defaultMaxBodyBytes
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+
+	// Description中の &amp; や &#39; などを文字に戻す
+	DecodeHTMLEntities bool `json:"decode_html_entities"`
+
+	// このバイト数を超えるDescriptionはgzip+base64で格納する。0なら圧縮しない
+	CompressDescriptionsOver int `json:"compress_descriptions_over"`
+
+	// 429/5xxのリトライ。0なら既定値を使う
+	MaxRetries       int `json:"max_retries"`
+	RetryBaseDelayMs int `json:"retry_base_delay_ms"`
+	// 待ち時間のジッター: none、full、equal。空ならequal
+	RetryJitter string `json:"retry_jitter"`
+
+	// 番組ごとの書き込み完了を記録するファイル。中断後の再実行で済んだ番組を飛ばす
+	CheckpointFile string `json:"checkpoint_file"`
+
+	// BatchWriteItemで書き込みを確認したエピソードを25件ごとに記録するファイル。
+	// 書き込み途中で中断した後の再実行で確認済みのものを送り直さない
+	WriteProgressFile string `json:"write_progress_file"`
+
+	// 配信先の国(ISO 3166-1 alpha-2)。リクエストに付け、番組のavailable_marketsに無ければ警告する
+	Market string `json:"market"`
+
+	// 2ページ目以降の1ページあたりのエピソード数(1〜50)。0なら50
+	PageSize int `json:"page_size"`
+
+	// Spotifyへのリクエストに使うHTTPプロキシ。未設定なら環境変数に従う
+	ProxyURL string `json:"proxy_url"`
+
+	// アクセストークンを次回の実行まで保存するファイル。未設定なら ~/.podcast/token.json、"-" なら保存しない
+	TokenCacheFile string `json:"token_cache_file"`
+
+	// 取得や書き込みに失敗した番組・エピソードを追記するJSONLファイル
+	DeadLetterFile string `json:"dead_letter_file"`
+
+	// config.jsonを他のユーザーが読める場合に警告ではなくエラーにする
+	StrictPermissions bool `json:"strict_permissions"`
+}
+
+const defaultMaxBodyBytes = 10 << 20
+
+var ErrBodyTooLarge = errors.New("response body too large")
+
+const httpTimeout = 30 * time.Second
+
+// Spotifyへのリクエストに使うクライアント。proxy_urlがあればそのプロキシを経由する
+func NewHTTPClient(config Config) (*http.Client, error) {
+	client := &http.Client{Timeout: httpTimeout}
+	if config.ProxyURL == "" {
+		return client, nil
+	}
+
+	proxy, err := url.Parse(config.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url %q: %w", config.ProxyURL, err)
+	}
+	if proxy.Scheme == "" || proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url %q: want a URL like http://proxy.example.com:8080", config.ProxyURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy)
+	client.Transport = transport
+
+	return client, nil
+}
+
+// 上限を超えるボディは読み切らずにエラーにする
+func readBody(config Config, body io.Reader) ([]byte, error) {
+	limit := config.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrBodyTooLarge, limit)
+	}
+
+	return data, nil
+}
+
+// Spotifyのデータから作る属性名。ExtraAttributesでは使えない
+var itemAttributeNames = []string{
+	"ID", "Name", "SortKey", "Description", "SafeHTMLDescription", "DurationMs", "ReleaseDate",
+	"Explicit", "SpotifyURL", "AudioPreviewURL", "Popularity", "ShowID", "LastUpdated", "DescriptionEncoding",
+	"Images", "Languages",
+}
+
+// スクリプトや危険な属性を取り除き、書式タグだけ残す
+var htmlPolicy = bluemonday.UGCPolicy()
+
+func (c Config) ValidateExtraAttributes() error {
+	for key := range c.ExtraAttributes {
+		for _, name := range itemAttributeNames {
+			if key == name {
+				return fmt.Errorf("extra attribute %q collides with an episode attribute", key)
+			}
+		}
+		if _, ok := c.AttributeMapping[key]; ok {
+			return fmt.Errorf("extra attribute %q collides with a mapped attribute", key)
+		}
+	}
+
+	return nil
+}
+
+const redacted = "********"
+
+// 表示用にシークレットを伏せたコピーを返す
+func (c Config) Redacted() Config {
+	if c.ClientSecret != "" {
+		c.ClientSecret = redacted
+	}
+
+	// ゲートウェイの認証ヘッダーなどが入るので値は伏せる
+	if len(c.ExtraHeaders) > 0 {
+		headers := make(map[string]string, len(c.ExtraHeaders))
+		for name := range c.ExtraHeaders {
+			headers[name] = redacted
+		}
+		c.ExtraHeaders = headers
+	}
+
+	// プロキシのURLに認証情報が含まれていればパスワードを伏せる
+	if proxy, err := url.Parse(c.ProxyURL); err == nil {
+		c.ProxyURL = proxy.Redacted()
+	}
+
+	// WebhookのURLはそれ自体が投稿の権限になる
+	if c.NotifyWebhookURL != "" {
+		c.NotifyWebhookURL = redacted
+	}
+
+	// エンドポイントに認証情報が含まれていれば取り除く
+	if endpoint, err := url.Parse(c.OTLPEndpoint); err == nil && endpoint.User != nil {
+		endpoint.User = nil
+		c.OTLPEndpoint = endpoint.String()
+	}
+
+	return c
+}
+
+func (c Config) ValidateExtraHeaders() error {
+	for name, value := range c.ExtraHeaders {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid extra header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid value for extra header %q", name)
+		}
+	}
+
+	return nil
+}
+
+// 設定済みのヘッダー(Authorizationなど)は上書きしない
+func setExtraHeaders(req *http.Request, config Config) {
+	for name, value := range config.ExtraHeaders {
+		if req.Header.Get(name) != "" {
+			continue
+		}
+		req.Header.Set(name, value)
+	}
 }
 
 type TokenResponse struct {
@@ -29,30 +247,18 @@ type TokenResponse struct {
 }
 
 type ProgramInfo struct {
-	AvailableMarkets []string `json:"available_markets"`
-	Copyrights       []any    `json:"copyrights"`
-	Description      string   `json:"description"`
-	Episodes         struct {
-		Href     string `json:"href"`
-		Items    []Item `json:"items"`
-		Limit    int    `json:"limit"`
-		Next     string `json:"next"`
-		Offset   int    `json:"offset"`
-		Previous any    `json:"previous"`
-		Total    int    `json:"total"`
-	} `json:"episodes"`
-	Explicit     bool `json:"explicit"`
-	ExternalUrls struct {
+	AvailableMarkets []string    `json:"available_markets"`
+	Copyrights       []any       `json:"copyrights"`
+	Description      string      `json:"description"`
+	Episodes         EpisodePage `json:"episodes"`
+	Explicit         bool        `json:"explicit"`
+	ExternalUrls     struct {
 		Spotify string `json:"spotify"`
 	} `json:"external_urls"`
-	Href            string `json:"href"`
-	HTMLDescription string `json:"html_description"`
-	ID              string `json:"id"`
-	Images          []struct {
-		Height int    `json:"height"`
-		URL    string `json:"url"`
-		Width  int    `json:"width"`
-	} `json:"images"`
+	Href               string   `json:"href"`
+	HTMLDescription    string   `json:"html_description"`
+	ID                 string   `json:"id"`
+	Images             []Image  `json:"images"`
 	IsExternallyHosted bool     `json:"is_externally_hosted"`
 	Languages          []string `json:"languages"`
 	MediaType          string   `json:"media_type"`
@@ -63,7 +269,14 @@ type ProgramInfo struct {
 	URI                string   `json:"uri"`
 }
 
-type ProgramInfoNext struct {
+type Image struct {
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+}
+
+// エピソードのページ。1ページ目は番組情報のepisodesに入り、2ページ目以降はこれ自体が返る
+type EpisodePage struct {
 	Href     string `json:"href"`
 	Items    []Item `json:"items"`
 	Limit    int    `json:"limit"`
@@ -76,31 +289,133 @@ type ProgramInfoNext struct {
 type Item struct {
 	AudioPreviewURL string `json:"audio_preview_url"`
 	Description     string `json:"description"`
-	DurationMs      int    `json:"duration_ms"`
+	DurationMs      int64  `json:"duration_ms"`
 	Explicit        bool   `json:"explicit"`
 	ExternalUrls    struct {
 		Spotify string `json:"spotify"`
 	} `json:"external_urls"`
-	Href            string `json:"href"`
-	HTMLDescription string `json:"html_description"`
-	ID              string `json:"id"`
-	Images          []struct {
-		Height int    `json:"height"`
-		URL    string `json:"url"`
-		Width  int    `json:"width"`
-	} `json:"images"`
+	Href                 string   `json:"href"`
+	HTMLDescription      string   `json:"html_description"`
+	ID                   string   `json:"id"`
+	Images               []Image  `json:"images"`
 	IsExternallyHosted   bool     `json:"is_externally_hosted"`
 	IsPlayable           bool     `json:"is_playable"`
 	Language             string   `json:"language"`
 	Languages            []string `json:"languages"`
 	Name                 string   `json:"name"`
+	Popularity           *int     `json:"popularity,omitempty"`
 	ReleaseDate          string   `json:"release_date"`
 	ReleaseDatePrecision string   `json:"release_date_precision"`
 	Type                 string   `json:"type"`
 	URI                  string   `json:"uri"`
+
+	// Spotifyのレスポンスには無い。取得時に番組IDを入れる
+	ShowID string `json:"show_id,omitempty"`
+	// 取得した時刻。LastUpdatedとno_clobberの比較に使う
+	FetchedAt time.Time `json:"-"`
 }
 
-func GetAccessToken(config Config) (TokenResponse, error) {
+func (i Item) SpotifyURL() string {
+	return i.ExternalUrls.Spotify
+}
+
+func itemToAttributes(config Config, item Item) map[string]*dynamodb.AttributeValue {
+	if len(config.AttributeMapping) > 0 {
+		return mappedAttributes(config, item)
+	}
+
+	// 名前は重複しうるのでキーはIDにする
+	attributes := map[string]*dynamodb.AttributeValue{
+		"ID": {
+			S: aws.String(item.ID),
+		},
+		"Name": {
+			S: aws.String(item.Name),
+		},
+		"Description": {
+			S: aws.String(item.Description),
+		},
+		"DurationMs": {
+			N: aws.String(strconv.FormatInt(item.DurationMs, 10)),
+		},
+		"ReleaseDate": {
+			S: aws.String(item.ReleaseDate),
+		},
+		"Explicit": {
+			BOOL: aws.Bool(item.Explicit),
+		},
+	}
+
+	// 名前はそのまま残し、並べ替え用のキーは別の属性にする
+	if config.usesSortKey() {
+		attributes["SortKey"] = &dynamodb.AttributeValue{
+			S: aws.String(EpisodeKey(config, item.Name)),
+		}
+	}
+
+	if config.StoreSafeHTMLDescription {
+		attributes["SafeHTMLDescription"] = &dynamodb.AttributeValue{
+			S: aws.String(htmlPolicy.Sanitize(item.HTMLDescription)),
+		}
+	}
+
+	if item.ShowID != "" {
+		attributes["ShowID"] = &dynamodb.AttributeValue{
+			S: aws.String(item.ShowID),
+		}
+	}
+
+	// 現在のAPIには無いので、ある場合だけ書く
+	if item.Popularity != nil {
+		attributes["Popularity"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.Itoa(*item.Popularity)),
+		}
+	}
+
+	// 外部URLやプレビューが無いエピソードは属性自体を書かない
+	if url := item.SpotifyURL(); url != "" {
+		attributes["SpotifyURL"] = &dynamodb.AttributeValue{
+			S: aws.String(url),
+		}
+	}
+	if item.AudioPreviewURL != "" {
+		attributes["AudioPreviewURL"] = &dynamodb.AttributeValue{
+			S: aws.String(item.AudioPreviewURL),
+		}
+	}
+
+	// 空のリストや文字列セットは書かない
+	if len(item.Images) > 0 {
+		attributes["Images"] = imagesToAttribute(item.Images)
+	}
+	if len(item.Languages) > 0 {
+		attributes["Languages"] = &dynamodb.AttributeValue{
+			SS: aws.StringSlice(item.Languages),
+		}
+	}
+
+	return attributes
+}
+
+// 画像をHeight/URL/Widthのマップのリストにする
+func imagesToAttribute(images []Image) *dynamodb.AttributeValue {
+	values := make([]*dynamodb.AttributeValue, len(images))
+	for i, image := range images {
+		values[i] = &dynamodb.AttributeValue{
+			M: map[string]*dynamodb.AttributeValue{
+				"Height": {N: aws.String(strconv.Itoa(image.Height))},
+				"URL":    {S: aws.String(image.URL)},
+				"Width":  {N: aws.String(strconv.Itoa(image.Width))},
+			},
+		}
+	}
+
+	return &dynamodb.AttributeValue{
+		L: values,
+	}
+}
+
+func GetAccessToken(ctx context.Context, client *http.Client, config Config) (TokenResponse, error) {
 	var tokenResponse TokenResponse
 
 	data := url.Values{}
@@ -108,29 +423,28 @@ func GetAccessToken(config Config) (TokenResponse, error) {
 	data.Set("client_id", config.ClientID)
 	data.Set("client_secret", config.ClientSecret)
 
-	req, err := http.NewRequest("POST", config.TokenURL, bytes.NewBufferString(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", config.TokenURL, bytes.NewBufferString(data.Encode()))
 	if err != nil {
 		return tokenResponse, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setExtraHeaders(req, config)
 
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
+	resp, err := doWithRetry(config, client, req)
 	if err != nil {
 		return tokenResponse, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("Unexpected status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(config, resp.Body)
 	if err != nil {
 		return tokenResponse, err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return tokenResponse, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
 	err = json.Unmarshal(body, &tokenResponse)
 	if err != nil {
 		return tokenResponse, err
@@ -139,143 +453,499 @@ func GetAccessToken(config Config) (TokenResponse, error) {
 	return tokenResponse, nil
 }
 
-func GetProgramData(tokenResponse TokenResponse, url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func GetProgramData(ctx context.Context, client *http.Client, config Config, manager *TokenManager, url string) ([]byte, error) {
+	body, _, err := GetProgramDataConditional(ctx, client, config, manager, url, nil)
+	return body, err
+}
+
+// stateがあればETag/Last-Modifiedで条件付きGETする。304ならbodyはnil
+func GetProgramDataConditional(ctx context.Context, client *http.Client, config Config, manager *TokenManager, url string, state *ShowState) ([]byte, bool, error) {
+	send := func(accessToken string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		if state != nil {
+			if state.ETag != "" {
+				req.Header.Set("If-None-Match", state.ETag)
+			}
+			if state.LastModified != "" {
+				req.Header.Set("If-Modified-Since", state.LastModified)
+			}
+		}
+		setExtraHeaders(req, config)
+
+		return doWithRetry(config, client, req)
+	}
+
+	accessToken, err := manager.Token(ctx)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	resp, err := send(accessToken)
+	if err != nil {
+		return nil, false, err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenResponse.AccessToken))
-	client := &http.Client{}
+	// 期限内でも取り消されたトークンは拒否される。捨てて1回だけ取り直す
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		slog.Warn("access token was rejected, requesting a new one", "url", url)
+		manager.Invalidate(accessToken)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+		accessToken, err = manager.Token(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		resp, err = send(accessToken)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("Unexpected status code: %d", resp.StatusCode)
+	if state != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, true, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(config, resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	// 204などボディの無い2xxは空の結果として扱う
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, false, nil
+	}
+
+	if state != nil {
+		etag := resp.Header.Get("ETag")
+		lastModified := resp.Header.Get("Last-Modified")
+
+		// 条件付きヘッダーに対応していない場合は総エピソード数で判定する
+		var pi ProgramInfo
+		if etag == "" && lastModified == "" || etag != "" && etag == state.ETag {
+			if json.Unmarshal(body, &pi) == nil && pi.TotalEpisodes == state.TotalEpisodes && state.TotalEpisodes > 0 {
+				return body, true, nil
+			}
+		}
+
+		state.ETag = etag
+		state.LastModified = lastModified
 	}
 
-	return body, nil
+	return body, false, nil
+}
+
+// 書き込みの結果
+type WriteResult struct {
+	// 書き込みが確認できた件数。失敗したときはそれまでに書けた件数
+	Written int
+	// 新規に追加されたエピソード
+	New []Item
+	// 保存済みの方が新しく書かなかった件数
+	Skipped int
 }
 
-func PutItem(items []Item) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String("us-west-2"),
-		Endpoint:    aws.String("http://localhost:8000"),
-		Credentials: credentials.NewStaticCredentials("dummy", "dummy", "dummy")},
-	)
+type WriteOptions struct {
+	// nilでなければエピソードを書くたびに進捗を送る
+	Progress chan<- Progress
+	// nilでなければ書き込めなかったエピソードを記録して残りを書き続ける
+	DeadLetters *DeadLetterWriter
+}
+
+// 書き込みが確認できた件数を返す。途中で失敗したときはそれまでの件数とエラーを返す
+// progressがnilでなければエピソードを書くたびに進捗を送る
+func PutItem(ctx context.Context, config Config, items []Item, progress chan<- Progress) (int, error) {
+	svc, err := newDynamoClient(config)
 	if err != nil {
-		log.Fatalf("Failed to create session: %v", err)
+		return 0, err
 	}
 
-	svc := dynamodb.New(sess)
+	result, err := writeItems(ctx, svc, config, items, WriteOptions{Progress: progress})
+	return result.Written, err
+}
 
-	for _, item := range items {
-		fmt.Println(item.Name, item.Description)
-		input := &dynamodb.PutItemInput{
-			TableName: aws.String("Program"),
-			Item: map[string]*dynamodb.AttributeValue{
-				"Name": {
-					S: aws.String(item.Name),
-				},
-				"Description": {
-					S: aws.String(item.Description),
-				},
-			},
-		}
+func writeItems(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, items []Item, options WriteOptions) (WriteResult, error) {
+	ctx, span := tracer.Start(ctx, "write")
+	span.SetAttributes(attribute.Int("items", len(items)))
+	defer span.End()
 
-		_, err = svc.PutItem(input)
+	var result WriteResult
+	var err error
+
+	// 条件付き書き込みや新着の判定が要らなければまとめて書く
+	if !config.NoClobber && config.NotifyType == "" {
+		result.Written, err = batchWriteItems(ctx, svc, config, items, options)
 		if err != nil {
-			log.Fatalf("Failed to put item: %v", err)
+			return result, fmt.Errorf("failed to put items: %w", err)
+		}
+
+		return result, nil
+	}
+
+	now := time.Now()
+
+	// テーブルがあればそのまま上書きし、無ければ初回として作成する
+	created := make(map[string]bool)
+
+	for i, item := range items {
+		isNew := false
+		written := false
+
+		slog.Debug("writing episode", "id", item.ID, "name", item.Name)
+		updated := lastUpdated(item, now)
+		attributes := writeAttributes(config, item, updated)
+
+		for _, tableName := range tablesForItem(config, item) {
+			if !created[tableName] {
+				err = EnsureTable(ctx, svc, tableName)
+				if err != nil {
+					return result, fmt.Errorf("failed to create table %s: %w", tableName, err)
+				}
+				created[tableName] = true
+			}
+
+			input := &dynamodb.PutItemInput{
+				TableName:    aws.String(tableName),
+				Item:         attributes,
+				ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+			}
+			if config.NoClobber {
+				input.ConditionExpression = aws.String("attribute_not_exists(ID) OR LastUpdated < :lastUpdated")
+				input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+					":lastUpdated": {S: aws.String(updated)},
+				}
+			}
+
+			output, err := svc.PutItemWithContext(ctx, input)
+			// 条件に合わないのは「更新不要」なので失敗ではなくスキップとして数える
+			if isConditionalCheckFailed(err) {
+				slog.Debug("skipping episode: stored data is newer", "id", item.ID, "table", tableName)
+				result.Skipped++
+				continue
+			}
+			if err != nil {
+				// dead letterに残せるなら残りのエピソードは書き続ける
+				if options.DeadLetters != nil {
+					slog.Error("failed to put item, recording it as a dead letter", "id", item.ID, "error", err)
+					options.DeadLetters.Record(DeadLetterEpisode, item.ID, item.ShowID, err)
+					continue
+				}
+				return result, fmt.Errorf("failed to put item %s: %w", item.ID, err)
+			}
+			written = true
+
+			// 上書き前の値が無ければ新規
+			if len(output.Attributes) == 0 {
+				isNew = true
+			}
 		}
+
+		if written {
+			result.Written++
+		}
+		if isNew {
+			result.New = append(result.New, item)
+		}
+
+		sendProgress(options.Progress, Progress{
+			Stage:  ProgressWrite,
+			ShowID: item.ShowID,
+			Done:   i + 1,
+			Total:  len(items),
+		})
 	}
 
-	fmt.Println("Successfully added item to table")
+	if result.Skipped > 0 {
+		slog.Info("skipped writes because the stored data was newer", "count", result.Skipped)
+	}
+
+	return result, nil
 }
 
-func main() {
-	// config読込
-	configFile, err := os.Open("config.json")
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func ImportItems(path string) ([]Item, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Failed to open config file: %v", err)
+		return nil, err
 	}
-	defer configFile.Close()
+	defer file.Close()
 
-	var config Config
-	err = json.NewDecoder(configFile).Decode(&config)
+	var items []Item
+	err = json.NewDecoder(file).Decode(&items)
 	if err != nil {
-		log.Fatalf("Failed to decode config file: %v", err)
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
 	}
 
+	return items, nil
+}
+
+type FetchOptions struct {
+	Window DateWindow
+	// 前回の状態。あれば条件付きGETで変更の有無を確認する
+	State *ShowState
+	// 途中のページで失敗しても取得済みのページまでを返す
+	AllowPartial bool
+	// ページごとの継続・終了の判断をログに出す
+	Explain bool
+	// nilでなければページごとに進捗を送る
+	Progress chan<- Progress
+	// 0でなければ、総エピソード数がこの数のままなら1ページ目で止めてUnchangedを返す
+	Checkpoint int
+	// 2以上なら2ページ目以降のURLを総数とoffsetから求め、この数のページずつ並列に取得する
+	Concurrency int
+}
+
+type FetchResult struct {
+	Info      ProgramInfo
+	Items     []Item
+	Unchanged bool
+	Partial   bool
+	// 1ページ目を要求した時刻。これより古いデータは含まない
+	FetchedAt time.Time
+}
+
+// 1番組あたりに取得するページ数の上限
+const maxPages = 1000
+
+// 複数の番組で同じトークンと接続を使い回すため、managerは呼び出し側で作る
+func FetchItems(ctx context.Context, config Config, manager *TokenManager, program string, options FetchOptions) (FetchResult, error) {
+	window := options.Window
+	state := options.State
+	fetchedAt := time.Now()
+
 	// アクセストークン取得
-	tokenResponse, err := GetAccessToken(config)
+	_, err := manager.Token(ctx)
 	if err != nil {
-		log.Fatalf(err.Error())
+		return FetchResult{}, err
 	}
 
-	// データ取得
-	url := "https://api.spotify.com/v1/shows/"
-	program := "4zqDMbg9WSpC5l81gJCfEc"
-	url += program
+	// データ取得。番組情報にエピソードの1ページ目が含まれる
+	url := showURL(config, program)
+
+	pageCtx, span := tracer.Start(ctx, "fetch page")
+	span.SetAttributes(attribute.String("url", url), attribute.Int("page", 0))
+
+	body, unchanged, err := GetProgramDataConditional(pageCtx, manager.client, config, manager, url, state)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return FetchResult{}, err
+	}
+	span.End()
 
 	var pi ProgramInfo
-	var pin ProgramInfoNext
+	if unchanged {
+		return FetchResult{Info: pi, Unchanged: true, FetchedAt: fetchedAt}, nil
+	}
+
+	// 空のレスポンスはエピソード無しの番組とみなす
+	if body != nil {
+		err = json.Unmarshal(body, &pi)
+		if err != nil {
+			return FetchResult{}, err
+		}
+	}
+
+	totalItem := pi.TotalEpisodes
+	if options.Checkpoint > 0 && totalItem == options.Checkpoint {
+		return FetchResult{Info: pi, Unchanged: true, FetchedAt: fetchedAt}, nil
+	}
+	if state != nil {
+		state.TotalEpisodes = totalItem
+	}
+
 	var items []Item
+	// どの番組のいつ取得したエピソードか分かるようにする
+	done := func(partial bool) FetchResult {
+		for i := range items {
+			items[i].ShowID = program
+			items[i].FetchedAt = fetchedAt
+		}
+		return FetchResult{Info: pi, Items: items, Partial: partial, FetchedAt: fetchedAt}
+	}
 
-	var totalItem int
-	var readItem int
+	page := pi.Episodes
+	page.Next = withPageSize(page.Next, config.pageSize())
+	// 並列に取得して、まだ順に見ていないページ
+	var queued []EpisodePage
 
 	for i := 0; ; i++ {
-		body, err := GetProgramData(tokenResponse, url)
-		if err != nil {
-			log.Fatal(err.Error())
+		offset := len(items)
+		items = append(items, page.Items...)
+
+		sendProgress(options.Progress, Progress{
+			Stage:  ProgressFetch,
+			ShowID: program,
+			Pages:  i + 1,
+			Done:   len(items),
+			Total:  totalItem,
+		})
+		slog.Debug("page fetched", "show", program, "page", i, "offset", offset, "items", len(page.Items), "total", totalItem)
+
+		next := page.Next
+		explain := func(decision string) {
+			if options.Explain {
+				slog.Info("explain", "show", program, "page", i, "next", next, "read_items", len(items), "total_items", totalItem, "decision", decision)
+			}
 		}
 
-		if i == 0 {
-			err = json.Unmarshal(body, &pi)
-			if err != nil {
-				log.Fatal(err.Error())
-			}
+		if next == "" {
+			explain("stop: no next page")
+			break
+		}
 
-			totalItem = pi.TotalEpisodes
-			readItem += len(pi.Episodes.Items)
+		// 総数とページの合計は一致するとは限らないので、nextが続く限り取得する。
+		// nextが同じ場所を指し続けた場合に備えて上限を設ける
+		if i+1 >= maxPages {
+			slog.Warn("show still has a next page at the page limit, stopping", "show", program, "max_pages", maxPages)
+			explain("stop: reached the page limit")
+			break
+		}
 
-			items = append(items, pi.Episodes.Items...)
+		// 範囲より古いエピソードに到達したらページングを止める
+		if len(items) > 0 && window.IsOlder(items[len(items)-1]) {
+			explain("stop: reached episodes older than -from")
+			break
+		}
 
-			if pi.Episodes.Next != "" {
-				url = pi.Episodes.Next
-			} else {
-				break
-			}
-		} else {
-			err = json.Unmarshal(body, &pin)
+		// 並列に取得済みのページがあれば順に使う
+		if len(queued) > 0 {
+			explain("continue")
+			page, queued = queued[0], queued[1:]
+			continue
+		}
+
+		// 総数から次の数ページを求めてまとめて並列に取得する。
+		// 取得後もページ順に範囲を確認し、最後のページのnextから続ける
+		if options.Concurrency > 1 {
+			explain(fmt.Sprintf("fetch the next pages with %d workers", options.Concurrency))
+			pages, failedPage, err := fetchPagesConcurrently(ctx, config, manager, next, totalItem, i+1, options.Concurrency)
 			if err != nil {
-				log.Fatal(err.Error())
+				if options.AllowPartial {
+					for _, fetched := range pages {
+						items = append(items, fetched.Items...)
+					}
+					slog.Error("failed to fetch page, keeping the episodes fetched so far", "show", program, "page", failedPage, "kept", len(items), "error", err)
+					return done(true), nil
+				}
+				return FetchResult{}, err
 			}
+			page, queued = pages[0], pages[1:]
+			continue
+		}
 
-			readItem += len(pin.Items)
+		explain("continue")
+		page, err = fetchPage(ctx, config, manager, next, i+1)
+		if err != nil {
+			// 2ページ目以降の失敗なら取得済みの分だけ返す
+			if options.AllowPartial {
+				slog.Error("failed to fetch page, keeping the episodes fetched so far", "show", program, "page", i+1, "kept", len(items), "error", err)
+				return done(true), nil
+			}
+			return FetchResult{}, err
+		}
+	}
 
-			items = append(items, pin.Items...)
+	// 削除や配信停止で総数とずれることがある。-fromで止めた場合は少なくて当然
+	if len(items) != totalItem && window.From.IsZero() {
+		slog.Warn("fetched episode count differs from the reported total", "show", program, "total", totalItem, "fetched", len(items))
+	}
 
-			if pin.Next != "" {
-				url = pin.Next
-			} else {
-				break
-			}
+	return done(false), nil
+}
+
+func SkipExternallyHosted(items []Item) ([]Item, int) {
+	var kept []Item
+	for _, item := range items {
+		if item.IsExternallyHosted {
+			continue
 		}
+		kept = append(kept, item)
+	}
 
-		if totalItem == readItem {
-			break
+	return kept, len(items) - len(kept)
+}
+
+func SkipExplicit(items []Item) ([]Item, int) {
+	var kept []Item
+	for _, item := range items {
+		if item.Explicit {
+			continue
 		}
+		kept = append(kept, item)
 	}
 
-	PutItem(items)
+	return kept, len(items) - len(kept)
+}
+
+func SkipUnplayable(items []Item) ([]Item, int) {
+	var kept []Item
+	for _, item := range items {
+		if !item.IsPlayable {
+			continue
+		}
+		kept = append(kept, item)
+	}
+
+	return kept, len(items) - len(kept)
+}
+
+// 番組が指定されなかったときの既定の番組
+const defaultShowID = "4zqDMbg9WSpC5l81gJCfEc"
+
+// -quiet-errors の場合、エラーはWARNとして出力して終了コード0で終わる
+var quietErrors bool
+
+// コマンドのエラーから終了コードを決める
+func exitCode(name string, err error) int {
+	if err == nil {
+		return 0
+	}
+	if quietErrors {
+		slog.Warn(name+" failed", "error", err)
+		return 0
+	}
+	slog.Error(name+" failed", "error", err)
+	return 1
+}
+
+func DecodeHTMLEntities(items []Item) {
+	for i := range items {
+		items[i].Description = html.UnescapeString(items[i].Description)
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// サブコマンドを実行して終了コードを返す
+func run(args []string) int {
+	if len(args) < 1 {
+		usage()
+		return 2
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			return exitCode(c.name, c.run(args[1:]))
+		}
+	}
+
+	if args[0] != "-h" && args[0] != "-help" && args[0] != "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	}
+	usage()
+	return 2
 }
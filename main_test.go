@@ -0,0 +1,870 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestExitCode(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	quietErrors = false
+	defer func() { quietErrors = false }()
+
+	if code := exitCode("fetch", nil); code != 0 {
+		t.Errorf("exitCode(nil) = %d, want 0", code)
+	}
+
+	err := errors.New("failed to fetch show")
+	if code := exitCode("fetch", err); code != 1 {
+		t.Errorf("exitCode(err) = %d, want 1", code)
+	}
+
+	quietErrors = true
+	buf.Reset()
+	if code := exitCode("fetch", err); code != 0 {
+		t.Errorf("exitCode(err) with -quiet-errors = %d, want 0", code)
+	}
+	if !strings.Contains(buf.String(), `WARN fetch failed error="failed to fetch show"`) {
+		t.Errorf("log = %q, want the error as a warning", buf.String())
+	}
+}
+
+func TestFetchItemsReturnsTokenError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	// 終了せずにエラーを返せば、呼び出し側の遅延処理が動く
+	config := Config{TokenURL: server.URL}
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewTokenManager(client, config)
+	_, err = FetchItems(context.Background(), config, manager, "show", FetchOptions{})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("FetchItems() error = %v, want the token status", err)
+	}
+}
+
+func TestSkipExplicit(t *testing.T) {
+	items := []Item{
+		{ID: "clean1"},
+		{ID: "explicit1", Explicit: true},
+		{ID: "clean2"},
+		{ID: "explicit2", Explicit: true},
+	}
+
+	kept, skipped := SkipExplicit(items)
+
+	if skipped != 2 {
+		t.Errorf("skipped = %d, want 2", skipped)
+	}
+	if want := []Item{{ID: "clean1"}, {ID: "clean2"}}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept = %+v, want %+v", kept, want)
+	}
+}
+
+// fnの間にos.Stdoutへ書かれた内容を返す
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	return captureOutput(t, &os.Stdout, fn)
+}
+
+// fnの間にos.Stderrへ書かれた内容を返す
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	return captureOutput(t, &os.Stderr, fn)
+}
+
+func captureOutput(t *testing.T, file **os.File, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := *file
+	*file = w
+	defer func() { *file = original }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestPrintConfigShowsMarketOverride(t *testing.T) {
+	var code int
+	out := captureStdout(t, func() {
+		code = run([]string{"fetch", "-print-config", "-market", "JP"})
+	})
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var printed Config
+	err := json.Unmarshal([]byte(out), &printed)
+	if err != nil {
+		t.Fatalf("output is not a config: %v\n%s", err, out)
+	}
+	if printed.Market != "JP" {
+		t.Errorf("printed market = %q, want JP", printed.Market)
+	}
+}
+
+func TestFetchImportWritesAllEpisodes(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	// 1回のBatchWriteItemに収まらない件数にする
+	items := testItems(30)
+	path := filepath.Join(t.TempDir(), "episodes.json")
+	err := ExportItems(items, ExportJSON, path, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := runCommand(t, config, "fetch", "-import", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var want []string
+	for _, item := range items {
+		want = append(want, item.ID)
+	}
+	got := dynamo.ids(config.tableName())
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored IDs = %v, want %v", got, want)
+	}
+	if n := len(spotify.requests); n != 0 {
+		t.Errorf("%d requests to Spotify, want none", n)
+	}
+}
+
+func TestSpotifyURLAttribute(t *testing.T) {
+	var item Item
+	err := json.Unmarshal([]byte(`{"id": "ep1", "external_urls": {"spotify": "https://open.spotify.com/episode/ep1"}}`), &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url := item.SpotifyURL(); url != "https://open.spotify.com/episode/ep1" {
+		t.Errorf("SpotifyURL() = %q", url)
+	}
+	attributes := itemToAttributes(Config{}, item)
+	if url := aws.StringValue(attributes["SpotifyURL"].S); url != item.SpotifyURL() {
+		t.Errorf("SpotifyURL attribute = %q, want %q", url, item.SpotifyURL())
+	}
+
+	// URLの無いエピソードは属性を書かない
+	empty := Item{ID: "ep2"}
+	if url := empty.SpotifyURL(); url != "" {
+		t.Errorf("SpotifyURL() = %q, want empty", url)
+	}
+	if _, ok := itemToAttributes(Config{}, empty)["SpotifyURL"]; ok {
+		t.Error("SpotifyURL attribute written for an episode without a URL")
+	}
+}
+
+func TestSkipExternallyHosted(t *testing.T) {
+	items := []Item{
+		{ID: "spotify1"},
+		{ID: "external1", IsExternallyHosted: true},
+		{ID: "spotify2"},
+	}
+
+	kept, skipped := SkipExternallyHosted(items)
+
+	if skipped != 1 {
+		t.Errorf("skipped = %d, want 1", skipped)
+	}
+	if want := []Item{{ID: "spotify1"}, {ID: "spotify2"}}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept = %+v, want %+v", kept, want)
+	}
+}
+
+func TestFetchSkipExternal(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3).Episodes[1].IsExternallyHosted = true
+	spotify.addShow(testShowB, 2).Info.IsExternallyHosted = true
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	code := runCommand(t, config, "fetch", "-skip-external", "-show", testShowA+","+testShowB)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	got := dynamo.ids(config.tableName())
+	sort.Strings(got)
+	if want := []string{"1111-001", "1111-003"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored IDs = %v, want %v", got, want)
+	}
+}
+
+func TestSafeHTMLDescription(t *testing.T) {
+	item := Item{
+		ID:              "ep1",
+		HTMLDescription: `<p>Hello <b>world</b> <a href="https://example.com" onclick="steal()">link</a></p><script>alert(1)</script>`,
+	}
+
+	if _, ok := itemToAttributes(Config{}, item)["SafeHTMLDescription"]; ok {
+		t.Error("SafeHTMLDescription written without store_safe_html_description")
+	}
+
+	html := aws.StringValue(itemToAttributes(Config{StoreSafeHTMLDescription: true}, item)["SafeHTMLDescription"].S)
+	for _, removed := range []string{"<script", "alert(1)", "onclick"} {
+		if strings.Contains(html, removed) {
+			t.Errorf("%q was not removed: %s", removed, html)
+		}
+	}
+	for _, kept := range []string{"<p>", "<b>world</b>", `href="https://example.com"`} {
+		if !strings.Contains(html, kept) {
+			t.Errorf("%q was removed: %s", kept, html)
+		}
+	}
+}
+
+func TestExtraHeaders(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 1)
+	config := testConfig(spotify, "")
+	config.ExtraHeaders = map[string]string{"X-Gateway-Key": "key", "Authorization": "Bearer other"}
+
+	_, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := spotify.requestsTo("/v1/shows/" + testShowA)
+	if len(requests) != 1 {
+		t.Fatalf("%d show requests, want 1", len(requests))
+	}
+	header := requests[0].Header
+	if got := header.Get("X-Gateway-Key"); got != "key" {
+		t.Errorf("X-Gateway-Key = %q, want key", got)
+	}
+	if got := header.Get("Authorization"); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want the access token", got)
+	}
+
+	if err := (Config{ExtraHeaders: map[string]string{"Bad Header": "x"}}).ValidateExtraHeaders(); err == nil {
+		t.Error("invalid header name accepted")
+	}
+	if err := (Config{ExtraHeaders: map[string]string{"X-Ok": "a\nb"}}).ValidateExtraHeaders(); err == nil {
+		t.Error("header value with a newline accepted")
+	}
+}
+
+func TestNoClobberKeepsNewerItems(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	dynamo.seed("Program", map[string]*dynamodb.AttributeValue{
+		"ID":          {S: aws.String("ep001")},
+		"Name":        {S: aws.String("edited later")},
+		"LastUpdated": {S: aws.String("2999-01-01T00:00:00Z")},
+	}, map[string]*dynamodb.AttributeValue{
+		"ID":          {S: aws.String("ep002")},
+		"Name":        {S: aws.String("stale")},
+		"LastUpdated": {S: aws.String("2000-01-01T00:00:00Z")},
+	})
+	config := Config{NoClobber: true}
+
+	result, err := writeItems(context.Background(), dynamo, config, testItems(3), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Written != 2 || result.Skipped != 1 {
+		t.Errorf("Written, Skipped = %d, %d, want 2, 1", result.Written, result.Skipped)
+	}
+	names := make(map[string]string)
+	for _, item := range dynamo.items("Program") {
+		names[aws.StringValue(item["ID"].S)] = aws.StringValue(item["Name"].S)
+	}
+	want := map[string]string{"ep001": "edited later", "ep002": "Episode 2", "ep003": "Episode 3"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("stored names = %v, want %v", names, want)
+	}
+}
+
+func TestNoClobberComparesFetchTime(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 2)
+	config := testConfig(spotify, "")
+
+	before := time.Now()
+	result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FetchedAt.Before(before) || result.FetchedAt.After(time.Now()) {
+		t.Fatalf("FetchedAt = %v, want the time of the fetch", result.FetchedAt)
+	}
+	for _, item := range result.Items {
+		if !item.FetchedAt.Equal(result.FetchedAt) {
+			t.Errorf("episode %s FetchedAt = %v, want %v", item.ID, item.FetchedAt, result.FetchedAt)
+		}
+	}
+
+	// 取得した後、書き込む前に別の同期が新しいデータを書いた
+	result.Items[0].FetchedAt = time.Now().Add(-time.Hour)
+	result.Items[1].FetchedAt = time.Now().Add(-time.Hour)
+	stored := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	dynamo := newFakeDynamoDB()
+	dynamo.seed("Program", map[string]*dynamodb.AttributeValue{
+		"ID":          {S: aws.String(result.Items[0].ID)},
+		"Name":        {S: aws.String("written by a newer sync")},
+		"LastUpdated": {S: aws.String(stored)},
+	})
+
+	written, err := writeItems(context.Background(), dynamo, Config{NoClobber: true}, result.Items, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if written.Written != 1 || written.Skipped != 1 {
+		t.Errorf("Written, Skipped = %d, %d, want 1, 1", written.Written, written.Skipped)
+	}
+	want := result.Items[1].FetchedAt.UTC().Format(time.RFC3339)
+	for _, item := range dynamo.items("Program") {
+		id, updated := aws.StringValue(item["ID"].S), aws.StringValue(item["LastUpdated"].S)
+		if id == result.Items[0].ID && updated != stored {
+			t.Errorf("newer episode %s was overwritten: LastUpdated = %s", id, updated)
+		}
+		if id == result.Items[1].ID && updated != want {
+			t.Errorf("episode %s LastUpdated = %s, want the fetch time %s", id, updated, want)
+		}
+	}
+}
+
+func TestLargeDurationMs(t *testing.T) {
+	// float64では丸められる値
+	const duration = 9007199254740993
+
+	var item Item
+	err := json.Unmarshal([]byte(`{"id": "ep1", "duration_ms": 9007199254740993}`), &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.DurationMs != duration {
+		t.Errorf("DurationMs = %d, want %d", item.DurationMs, int64(duration))
+	}
+
+	attributes := itemToAttributes(Config{}, item)
+	if n := aws.StringValue(attributes["DurationMs"].N); n != "9007199254740993" {
+		t.Errorf("DurationMs attribute = %s", n)
+	}
+	decoded, err := attributesToItem(attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.DurationMs != duration {
+		t.Errorf("decoded DurationMs = %d, want %d", decoded.DurationMs, int64(duration))
+	}
+}
+
+func TestFetchAllowPartial(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 8)
+	// 4ページのうち3ページ目だけ失敗させる
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/episodes") && r.URL.Query().Get("offset") == "4" {
+			http.Error(w, `{"error":{"status":500,"message":"Server error"}}`, http.StatusInternalServerError)
+			return true
+		}
+		return false
+	}
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	manager := testTokenManager(spotify, config)
+
+	if _, err := FetchItems(context.Background(), config, manager, testShowA, FetchOptions{}); err == nil {
+		t.Fatal("failed page did not return an error without AllowPartial")
+	}
+
+	result, err := FetchItems(context.Background(), config, manager, testShowA, FetchOptions{AllowPartial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Partial {
+		t.Error("result not marked partial")
+	}
+	want := []string{"1111-008", "1111-007", "1111-006", "1111-005"}
+	if got := itemIDs(result.Items); !reflect.DeepEqual(got, want) {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+	for _, item := range result.Items {
+		if item.ShowID != testShowA {
+			t.Errorf("%s has ShowID %q", item.ID, item.ShowID)
+		}
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	config := testConfig(spotify, "")
+	config.MaxBodyBytes = 64
+
+	_, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("err = %v, want ErrBodyTooLarge", err)
+	}
+
+	// 上限ちょうどは読める
+	data, err := readBody(config, strings.NewReader(strings.Repeat("x", 64)))
+	if err != nil || len(data) != 64 {
+		t.Errorf("readBody() = %d bytes, %v, want 64 bytes", len(data), err)
+	}
+	if _, err := readBody(config, strings.NewReader(strings.Repeat("x", 65))); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("readBody() err = %v, want ErrBodyTooLarge", err)
+	}
+}
+
+func TestFetchExplain(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 6)
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	manager := testTokenManager(spotify, config)
+
+	explained := func(options FetchOptions) []string {
+		t.Helper()
+
+		logs := captureLogs(t)
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+		options.Explain = true
+		_, err := FetchItems(context.Background(), config, manager, testShowA, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decisions []string
+		for _, record := range slogRecords(t, logs.String()) {
+			if record["msg"] != "explain" {
+				continue
+			}
+			decisions = append(decisions, fmt.Sprintf("page=%v read=%v total=%v next=%t %v",
+				record["page"], record["read_items"], record["total_items"], record["next"] != "", record["decision"]))
+		}
+		return decisions
+	}
+
+	got := explained(FetchOptions{})
+	want := []string{
+		"page=0 read=2 total=6 next=true continue",
+		"page=1 read=4 total=6 next=true continue",
+		"page=2 read=6 total=6 next=false stop: no next page",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decisions = %q, want %q", got, want)
+	}
+
+	// 2024-01-03のエピソードを読んだ2ページ目で止まる
+	got = explained(FetchOptions{Window: DateWindow{From: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)}})
+	want = []string{
+		"page=0 read=2 total=6 next=true continue",
+		"page=1 read=4 total=6 next=true stop: reached episodes older than -from",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decisions with -from = %q, want %q", got, want)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")); n != 3 {
+		t.Errorf("%d episode page requests, want 3", n)
+	}
+}
+
+func TestDecodeHTMLEntities(t *testing.T) {
+	const description = "Q&amp;A with Tom &amp; Jerry&#39;s friends"
+
+	for _, tt := range []struct {
+		decode bool
+		want   string
+	}{
+		{true, "Q&A with Tom & Jerry's friends"},
+		{false, description},
+	} {
+		t.Run(fmt.Sprint(tt.decode), func(t *testing.T) {
+			spotify := newFakeSpotify(t)
+			show := spotify.addShow(testShowA, 1)
+			show.Episodes[0].Description = description
+			dynamo := newFakeDynamoDB()
+			config := testConfig(spotify, dynamo.serve(t))
+			config.DecodeHTMLEntities = tt.decode
+
+			if code := runCommand(t, config, "fetch", "-show", testShowA); code != 0 {
+				t.Fatalf("exit code = %d, want 0", code)
+			}
+
+			items := dynamo.items(config.tableName())
+			if len(items) != 1 {
+				t.Fatalf("%d items stored, want 1", len(items))
+			}
+			if got := aws.StringValue(items[0]["Description"].S); got != tt.want {
+				t.Errorf("Description = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionalCheckFailedIsSkipped(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	dynamo.fail = func(op string, input any) error {
+		if put, ok := input.(*dynamodb.PutItemInput); ok && aws.StringValue(put.Item["ID"].S) == "ep002" {
+			return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+		return nil
+	}
+	config := Config{NoClobber: true}
+	deadLetterFile := filepath.Join(t.TempDir(), "dead.jsonl")
+	deadLetters, err := OpenDeadLetters(deadLetterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deadLetters.Close()
+
+	result, err := writeItems(context.Background(), dynamo, config, testItems(3), WriteOptions{DeadLetters: deadLetters})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Written != 2 || result.Skipped != 1 {
+		t.Errorf("Written, Skipped = %d, %d, want 2, 1", result.Written, result.Skipped)
+	}
+	// 再試行しない
+	if n := dynamo.countCalls("PutItem"); n != 3 {
+		t.Errorf("%d PutItem calls, want 3", n)
+	}
+	if letters := readDeadLetters(t, deadLetterFile); len(letters) != 0 {
+		t.Errorf("skipped write recorded as a dead letter: %+v", letters)
+	}
+}
+
+func TestNon2xxReturnsError(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 1)
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.URL.Path {
+		case "/token":
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		case "/v1/shows/" + testShowA:
+			http.Error(w, `{"error":{"status":500,"message":"Server error"}}`, http.StatusInternalServerError)
+		default:
+			return false
+		}
+		return true
+	}
+	config := testConfig(spotify, "")
+
+	// プロセスを終了させずに何度でも呼べる
+	for i := 0; i < 2; i++ {
+		_, err := GetAccessToken(context.Background(), spotify.Client(), config)
+		if err == nil || !strings.Contains(err.Error(), "401") || !strings.Contains(err.Error(), "invalid_client") {
+			t.Errorf("GetAccessToken() err = %v, want the status and body", err)
+		}
+	}
+
+	manager := testTokenManager(spotify, config)
+	manager.token = TokenResponse{AccessToken: "token", TokenType: "Bearer"}
+	manager.expiry = time.Now().Add(time.Hour)
+	_, err := GetProgramData(context.Background(), spotify.Client(), config, manager, showURL(config, testShowA))
+	if err == nil || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "Server error") {
+		t.Errorf("GetProgramData() err = %v, want the status and body", err)
+	}
+}
+
+func TestGetProgramDataCanceled(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 1)
+	started := make(chan struct{})
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/shows/"+testShowA {
+			return false
+		}
+		close(started)
+		<-r.Context().Done()
+		return true
+	}
+	config := testConfig(spotify, "")
+	manager := testTokenManager(spotify, config)
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err := GetProgramData(ctx, spotify.Client(), config, manager, showURL(config, testShowA))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+
+	// 取り消し済みならリクエストを送らない
+	_, err = GetProgramData(ctx, spotify.Client(), config, manager, showURL(config, testShowA))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA)); n != 1 {
+		t.Errorf("%d show requests, want 1", n)
+	}
+}
+
+func TestNoContentIsEmpty(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 4)
+	spotify.addShow(testShowB, 4)
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		// Aは番組情報、Bは2ページ目が空
+		if r.URL.Path == "/v1/shows/"+testShowA || r.URL.Path == "/v1/shows/"+testShowB+"/episodes" {
+			w.WriteHeader(http.StatusNoContent)
+			return true
+		}
+		return false
+	}
+	config := testConfig(spotify, "")
+	manager := testTokenManager(spotify, config)
+
+	body, err := GetProgramData(context.Background(), spotify.Client(), config, manager, showURL(config, testShowA))
+	if err != nil || body != nil {
+		t.Errorf("GetProgramData() = %q, %v, want an empty body", body, err)
+	}
+
+	result, err := FetchItems(context.Background(), config, manager, testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 0 {
+		t.Errorf("%d items for a 204 show, want none", len(result.Items))
+	}
+
+	result, err = FetchItems(context.Background(), config, manager, testShowB, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := itemIDs(result.Items), []string{"2222-004", "2222-003"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("items = %v, want the first page %v", got, want)
+	}
+}
+
+type recordingTransport struct {
+	base http.RoundTripper
+
+	mu     sync.Mutex
+	reused []bool
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+			rt.reused = append(rt.reused, info.Reused)
+		},
+	}
+	return rt.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func TestFetchReusesClient(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 10)
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	transport := &recordingTransport{base: spotify.Client().Transport}
+	client := &http.Client{Transport: transport}
+
+	_, err := FetchItems(context.Background(), config, NewTokenManager(client, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// トークン、番組情報、残り4ページ
+	if n := len(transport.reused); n != 6 {
+		t.Fatalf("%d requests through the client, want 6", n)
+	}
+	for i, reused := range transport.reused[1:] {
+		if !reused {
+			t.Errorf("request %d opened a new connection", i+2)
+		}
+	}
+}
+
+func TestSkipUnplayable(t *testing.T) {
+	items := []Item{
+		{ID: "playable1", IsPlayable: true},
+		{ID: "unplayable1"},
+		{ID: "playable2", IsPlayable: true},
+		{ID: "unplayable2"},
+	}
+
+	kept, skipped := SkipUnplayable(items)
+
+	if skipped != 2 {
+		t.Errorf("skipped = %d, want 2", skipped)
+	}
+	if got, want := itemIDs(kept), []string{"playable1", "playable2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept = %v, want %v", got, want)
+	}
+}
+
+func TestFetchPlayableOnly(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	show := spotify.addShow(testShowA, 4)
+	show.Episodes[0].IsPlayable = false
+	show.Episodes[2].IsPlayable = false
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	path := filepath.Join(t.TempDir(), "episodes.json")
+
+	code := runCommand(t, config, "fetch", "-playable-only", "-export", path, "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	want := []string{"1111-001", "1111-003"}
+	got := dynamo.ids(config.tableName())
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored IDs = %v, want %v", got, want)
+	}
+	exported, err := ImportItems(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = itemIDs(exported)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exported IDs = %v, want %v", got, want)
+	}
+}
+
+type fakeProxy struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	urls []string
+}
+
+func newFakeProxy(t *testing.T) *fakeProxy {
+	p := &fakeProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.urls = append(p.urls, r.URL.String())
+		p.mu.Unlock()
+
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestFetchThroughProxy(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	proxy := newFakeProxy(t)
+	config := testConfig(spotify, "")
+	config.ProxyURL = proxy.URL
+
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := FetchItems(context.Background(), config, NewTokenManager(client, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 3 {
+		t.Errorf("%d items, want 3", len(result.Items))
+	}
+
+	// トークン、番組情報、2ページ目のすべてがプロキシを通る
+	if len(proxy.urls) != 3 {
+		t.Fatalf("proxy received %v, want 3 requests", proxy.urls)
+	}
+	for i, prefix := range []string{spotify.URL + "/token", spotify.URL + "/v1/shows/" + testShowA, spotify.URL + "/v1/shows/" + testShowA + "/episodes"} {
+		if !strings.HasPrefix(proxy.urls[i], prefix) {
+			t.Errorf("proxy request %d = %s, want %s", i+1, proxy.urls[i], prefix)
+		}
+	}
+
+	for _, proxyURL := range []string{"proxy.example.com:8080", "http://%zz", "http://"} {
+		if _, err := NewHTTPClient(Config{ProxyURL: proxyURL}); err == nil || !strings.Contains(err.Error(), "proxy_url") {
+			t.Errorf("NewHTTPClient() with proxy_url %q = %v, want an error", proxyURL, err)
+		}
+	}
+}
+
+func TestFetchSkipExplicitAndMarket(t *testing.T) {
+	logs := captureLogs(t)
+	spotify := newFakeSpotify(t)
+	show := spotify.addShow(testShowA, 4)
+	show.Info.AvailableMarkets = []string{"US", "GB"}
+	show.Episodes[0].Explicit = true
+	show.Episodes[2].Explicit = true
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	code := runCommand(t, config, "fetch", "-log-format", "json", "-skip-explicit", "-market", "jp", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	for _, item := range dynamo.items(config.tableName()) {
+		if aws.BoolValue(item["Explicit"].BOOL) {
+			t.Errorf("explicit episode %s was written", aws.StringValue(item["ID"].S))
+		}
+	}
+	if n := len(dynamo.items(config.tableName())); n != 2 {
+		t.Errorf("stored %d episodes, want the 2 clean ones", n)
+	}
+
+	record := findRecord(slogRecords(t, logs.String()), "WARN", "show is not available in the market")
+	if record == nil || record["market"] != "jp" {
+		t.Errorf("no market warning: %v", record)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// "external_urls.spotify" のようなJSONのフィールド名のパスでItemのフィールドを探す
+func lookupField(v reflect.Value, path string) (reflect.Value, bool) {
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+			if tag == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+
+	return v, true
+}
+
+func isMappableKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64:
+		return true
+	case reflect.Pointer:
+		return isMappableKind(t.Elem())
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+func (c Config) ValidateAttributeMapping() error {
+	if len(c.AttributeMapping) == 0 {
+		return nil
+	}
+
+	if _, ok := c.AttributeMapping["ID"]; !ok {
+		return fmt.Errorf("attribute mapping must include the ID key attribute")
+	}
+
+	for attribute, path := range c.AttributeMapping {
+		field, ok := lookupField(reflect.ValueOf(Item{}), path)
+		if !ok {
+			return fmt.Errorf("attribute %q maps to unknown field %q", attribute, path)
+		}
+		if !isMappableKind(field.Type()) {
+			return fmt.Errorf("attribute %q maps to field %q of unsupported type %s", attribute, path, field.Type())
+		}
+	}
+
+	return nil
+}
+
+// 空の値(nilのポインタや空のスライス)は書かないのでnilを返す
+func fieldToAttribute(v reflect.Value) *dynamodb.AttributeValue {
+	switch v.Kind() {
+	case reflect.String:
+		return &dynamodb.AttributeValue{S: aws.String(v.String())}
+	case reflect.Bool:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(v.Bool())}
+	case reflect.Int, reflect.Int64:
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(v.Int(), 10))}
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return fieldToAttribute(v.Elem())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		var values []*string
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, aws.String(v.Index(i).String()))
+		}
+		return &dynamodb.AttributeValue{SS: values}
+	default:
+		return nil
+	}
+}
+
+func mappedAttributes(config Config, item Item) map[string]*dynamodb.AttributeValue {
+	attributes := make(map[string]*dynamodb.AttributeValue)
+	for attribute, path := range config.AttributeMapping {
+		field, _ := lookupField(reflect.ValueOf(item), path)
+		if value := fieldToAttribute(field); value != nil {
+			attributes[attribute] = value
+		}
+	}
+
+	if config.usesSortKey() {
+		attributes["SortKey"] = &dynamodb.AttributeValue{S: aws.String(EpisodeKey(config, item.Name))}
+	}
+
+	return attributes
+}
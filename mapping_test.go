@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestMappedAttributes(t *testing.T) {
+	popularity := 42
+	item := Item{
+		ID:         "ep1",
+		Name:       "Episode 1",
+		DurationMs: 60000,
+		Explicit:   true,
+		Languages:  []string{"en", "ja"},
+		Popularity: &popularity,
+	}
+	item.ExternalUrls.Spotify = "https://open.spotify.com/episode/ep1"
+	config := Config{AttributeMapping: map[string]string{
+		"ID":         "id",
+		"Title":      "name",
+		"Length":     "duration_ms",
+		"Explicit":   "explicit",
+		"Link":       "external_urls.spotify",
+		"Languages":  "languages",
+		"Popularity": "popularity",
+	}}
+	if err := config.ValidateAttributeMapping(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := itemToAttributes(config, item)
+
+	want := map[string]*dynamodb.AttributeValue{
+		"ID":         {S: aws.String("ep1")},
+		"Title":      {S: aws.String("Episode 1")},
+		"Length":     {N: aws.String("60000")},
+		"Explicit":   {BOOL: aws.Bool(true)},
+		"Link":       {S: aws.String("https://open.spotify.com/episode/ep1")},
+		"Languages":  {SS: aws.StringSlice([]string{"en", "ja"})},
+		"Popularity": {N: aws.String("42")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("itemToAttributes() = %v, want %v", got, want)
+	}
+
+	// nilのポインタや空のスライスは書かない
+	got = itemToAttributes(config, Item{ID: "ep2"})
+	for _, name := range []string{"Languages", "Popularity"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("empty %s written", name)
+		}
+	}
+}
+
+func TestValidateAttributeMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping map[string]string
+	}{
+		{"missing ID", map[string]string{"Title": "name"}},
+		{"unknown field", map[string]string{"ID": "id", "Title": "title"}},
+		{"unsupported type", map[string]string{"ID": "id", "Images": "images"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (Config{AttributeMapping: tt.mapping}).ValidateAttributeMapping(); err == nil {
+				t.Errorf("mapping %v accepted", tt.mapping)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Discordは1メッセージにembedを10個まで、Slackはblockを50個まで
+const (
+	discordMaxEmbeds = 10
+	slackMaxBlocks   = 50
+)
+
+// Webhookの送信先が応答しなくても実行が止まらないようタイムアウトを設ける
+var webhookClient = &http.Client{Timeout: httpTimeout}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text,omitempty"`
+}
+
+type SlackPayload struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type discordEmbed struct {
+	Title       string `json:"title"`
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description"`
+}
+
+type DiscordPayload struct {
+	Content string         `json:"content"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+func (c Config) ValidateNotifier() error {
+	switch c.NotifyType {
+	case "":
+		return nil
+	case "slack", "discord":
+		if c.NotifyWebhookURL == "" {
+			return fmt.Errorf("notify_webhook_url is required for %s notifications", c.NotifyType)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown notify type %q (want slack or discord)", c.NotifyType)
+	}
+}
+
+// mrkdwnで制御文字として読まれる文字をエスケープする
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// リンクの表示名では | が区切りになるので全角に置き換える
+var slackLabelEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "|", "｜")
+
+func SlackPayloads(items []Item) []SlackPayload {
+	var payloads []SlackPayload
+	for start := 0; start < len(items); start += slackMaxBlocks - 1 {
+		end := min(start+slackMaxBlocks-1, len(items))
+
+		payload := SlackPayload{
+			Text: fmt.Sprintf("%d new episodes", len(items)),
+			Blocks: []slackBlock{
+				{Type: "header", Text: &slackText{Type: "plain_text", Text: "New episodes"}},
+			},
+		}
+		for _, item := range items[start:end] {
+			title := slackEscaper.Replace(item.Name)
+			if url := item.SpotifyURL(); url != "" {
+				title = fmt.Sprintf("<%s|%s>", slackEscaper.Replace(url), slackLabelEscaper.Replace(item.Name))
+			}
+			payload.Blocks = append(payload.Blocks, slackBlock{
+				Type: "section",
+				Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", title, slackEscaper.Replace(item.ReleaseDate))},
+			})
+		}
+		payloads = append(payloads, payload)
+	}
+
+	return payloads
+}
+
+func DiscordPayloads(items []Item) []DiscordPayload {
+	var payloads []DiscordPayload
+	for start := 0; start < len(items); start += discordMaxEmbeds {
+		end := min(start+discordMaxEmbeds, len(items))
+
+		payload := DiscordPayload{
+			Content: fmt.Sprintf("%d new episodes", len(items)),
+		}
+		for _, item := range items[start:end] {
+			payload.Embeds = append(payload.Embeds, discordEmbed{
+				Title:       item.Name,
+				URL:         item.SpotifyURL(),
+				Description: "Released " + item.ReleaseDate,
+			})
+		}
+		payloads = append(payloads, payload)
+	}
+
+	return payloads
+}
+
+func NotifyNewEpisodes(ctx context.Context, config Config, items []Item) error {
+	if config.NotifyType == "" || len(items) == 0 {
+		return nil
+	}
+
+	var payloads []any
+	switch config.NotifyType {
+	case "slack":
+		for _, payload := range SlackPayloads(items) {
+			payloads = append(payloads, payload)
+		}
+	case "discord":
+		for _, payload := range DiscordPayloads(items) {
+			payloads = append(payloads, payload)
+		}
+	}
+
+	for _, payload := range payloads {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", config.NotifyWebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s webhook returned status %d", config.NotifyType, resp.StatusCode)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func notifyItems(n int) []Item {
+	var items []Item
+	for i := 1; i <= n; i++ {
+		item := Item{ID: fmt.Sprintf("ep%d", i), Name: fmt.Sprintf("Episode %d", i), ReleaseDate: "2024-01-01"}
+		item.ExternalUrls.Spotify = "https://open.spotify.com/episode/" + item.ID
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestSlackPayloads(t *testing.T) {
+	payloads := SlackPayloads(notifyItems(60))
+
+	if len(payloads) != 2 {
+		t.Fatalf("%d payloads, want 2", len(payloads))
+	}
+	if n := len(payloads[0].Blocks); n != slackMaxBlocks {
+		t.Errorf("first payload has %d blocks, want %d", n, slackMaxBlocks)
+	}
+	if n := len(payloads[1].Blocks); n != 60-(slackMaxBlocks-1)+1 {
+		t.Errorf("second payload has %d blocks, want %d", n, 60-(slackMaxBlocks-1)+1)
+	}
+	first := payloads[0]
+	if first.Text != "60 new episodes" || first.Blocks[0].Type != "header" {
+		t.Errorf("payload starts with %q and a %s block, want the count and a header", first.Text, first.Blocks[0].Type)
+	}
+	if text := first.Blocks[1].Text.Text; text != "*<https://open.spotify.com/episode/ep1|Episode 1>*\n2024-01-01" {
+		t.Errorf("section text = %q", text)
+	}
+}
+
+func TestSlackPayloadsEscapeMrkdwn(t *testing.T) {
+	items := notifyItems(2)
+	items[0].Name = "Q&A <live> | part 1"
+	items[1].Name = "Q&A <live> | part 2"
+	items[1].ExternalUrls.Spotify = ""
+
+	blocks := SlackPayloads(items)[0].Blocks
+	if text, want := blocks[1].Text.Text, "*<https://open.spotify.com/episode/ep1|Q&amp;A &lt;live&gt; ｜ part 1>*\n2024-01-01"; text != want {
+		t.Errorf("linked section text = %q, want %q", text, want)
+	}
+	if text, want := blocks[2].Text.Text, "*Q&amp;A &lt;live&gt; | part 2*\n2024-01-01"; text != want {
+		t.Errorf("section text = %q, want %q", text, want)
+	}
+}
+
+func TestDiscordPayloads(t *testing.T) {
+	payloads := DiscordPayloads(notifyItems(11))
+
+	if len(payloads) != 2 || len(payloads[0].Embeds) != discordMaxEmbeds || len(payloads[1].Embeds) != 1 {
+		t.Fatalf("payloads = %+v, want 10 embeds then 1", payloads)
+	}
+	embed := payloads[0].Embeds[0]
+	if embed.Title != "Episode 1" || embed.URL != "https://open.spotify.com/episode/ep1" || embed.Description != "Released 2024-01-01" {
+		t.Errorf("embed = %+v", embed)
+	}
+}
+
+func TestNotifyNewEpisodesPostsPayloads(t *testing.T) {
+	var mu sync.Mutex
+	var received []DiscordPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var payload DiscordPayload
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		mu.Lock()
+		received = append(received, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := Config{NotifyType: "discord", NotifyWebhookURL: server.URL}
+	err := NotifyNewEpisodes(context.Background(), config, notifyItems(11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || len(received[0].Embeds) != 10 || len(received[1].Embeds) != 1 {
+		t.Errorf("received %+v, want 2 payloads with 10 and 1 embeds", received)
+	}
+}
+
+func TestNotifyNewEpisodesTimeout(t *testing.T) {
+	if webhookClient.Timeout <= 0 {
+		t.Fatal("webhook client has no timeout")
+	}
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	timeout := webhookClient.Timeout
+	webhookClient.Timeout = 50 * time.Millisecond
+	defer func() { webhookClient.Timeout = timeout }()
+
+	config := Config{NotifyType: "slack", NotifyWebhookURL: server.URL}
+	err := NotifyNewEpisodes(context.Background(), config, notifyItems(1))
+	if err == nil {
+		t.Error("NotifyNewEpisodes() succeeded against a hanging webhook, want a timeout error")
+	}
+}
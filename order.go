@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 人気順。人気度が無いエピソードは末尾に元の順序のまま並べる
+func SortByPopularity(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].Popularity, items[j].Popularity
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return *a > *b
+	})
+}
+
+// 公開日の古い順。公開日が読めないエピソードは末尾に元の順序のまま並べる
+func SortOldestFirst(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, _, errA := releaseDateRange(items[i])
+		b, _, errB := releaseDateRange(items[j])
+		if errA != nil || errB != nil {
+			return errA == nil && errB != nil
+		}
+		return a.Before(b)
+	})
+}
+
+func OrderItems(items []Item, order string) error {
+	switch order {
+	case "":
+		return nil
+	case "popularity":
+		SortByPopularity(items)
+		return nil
+	case "oldest":
+		SortOldestFirst(items)
+		return nil
+	default:
+		return fmt.Errorf("unknown order %q", order)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func itemIDs(items []Item) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestSortByPopularity(t *testing.T) {
+	var items []Item
+	err := json.Unmarshal([]byte(`[
+		{"id": "none1"},
+		{"id": "low", "popularity": 10},
+		{"id": "zero", "popularity": 0},
+		{"id": "none2"},
+		{"id": "high", "popularity": 80}
+	]`), &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].Popularity != nil || items[2].Popularity == nil || *items[2].Popularity != 0 {
+		t.Fatalf("popularity not decoded: missing = %v, zero = %v", items[0].Popularity, items[2].Popularity)
+	}
+
+	SortByPopularity(items)
+
+	// 人気度の無いエピソードは末尾に元の順序のまま残る
+	if got, want := itemIDs(items), []string{"high", "low", "zero", "none1", "none2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestOrderItemsUnknown(t *testing.T) {
+	if err := OrderItems(nil, "newest"); err == nil {
+		t.Error("unknown order accepted")
+	}
+}
+
+func TestFetchWritesOldestFirst(t *testing.T) {
+	for _, noClobber := range []bool{false, true} {
+		spotify := newFakeSpotify(t)
+		spotify.addShow(testShowA, 5)
+		dynamo := newFakeDynamoDB()
+		// 1件ずつ書く経路では書き込みの呼び出し順を記録する
+		var puts []string
+		dynamo.fail = func(op string, input any) error {
+			if put, ok := input.(*dynamodb.PutItemInput); ok {
+				puts = append(puts, aws.StringValue(put.Item["ID"].S))
+			}
+			return nil
+		}
+		config := testConfig(spotify, dynamo.serve(t))
+		config.NoClobber = noClobber
+
+		if code := runCommand(t, config, "fetch", "-order", "oldest", "-show", testShowA); code != 0 {
+			t.Fatalf("exit code = %d, want 0", code)
+		}
+
+		want := []string{"1111-001", "1111-002", "1111-003", "1111-004", "1111-005"}
+		if got := dynamo.ids(config.tableName()); !reflect.DeepEqual(got, want) {
+			t.Errorf("NoClobber %t: stored order = %v, want %v", noClobber, got, want)
+		}
+		if noClobber && !reflect.DeepEqual(puts, want) {
+			t.Errorf("PutItem order = %v, want %v", puts, want)
+		}
+	}
+}
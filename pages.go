@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Spotifyが1ページに返すエピソード数の上限
+const maxPageSize = 50
+
+// Spotify Web APIのベースURL。テストでは偽のサーバーに向ける
+var spotifyAPI = "https://api.spotify.com/v1"
+
+// 1ページあたりのエピソード数。未設定なら上限の50、それ以外は1〜50に丸める
+func (c Config) pageSize() int {
+	if c.PageSize == 0 {
+		return maxPageSize
+	}
+	return min(max(c.PageSize, 1), maxPageSize)
+}
+
+// 番組情報のURL。marketがあれば付ける。以降のnextにはSpotifyが引き継ぐ
+func showURL(config Config, showID string) string {
+	u := spotifyAPI + "/shows/" + url.PathEscape(showID)
+	if config.Market != "" {
+		u += "?" + url.Values{"market": {config.Market}}.Encode()
+	}
+	return u
+}
+
+// 1ページ目のnextにページの件数を指定する。2ページ目以降のnextはこの件数を引き継ぐので変えない
+func withPageSize(next string, size int) string {
+	if next == "" {
+		return next
+	}
+	u, err := url.Parse(next)
+	if err != nil {
+		return next
+	}
+
+	query := u.Query()
+	query.Set("limit", strconv.Itoa(size))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// nextの offset/limit から total までの残りのページのURLを作る
+func remainingPageURLs(next string, total int) ([]string, error) {
+	u, err := url.Parse(next)
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil {
+		return nil, fmt.Errorf("next page URL %q has no offset", next)
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("next page URL %q has no limit", next)
+	}
+
+	var urls []string
+	for ; offset < total; offset += limit {
+		query.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = query.Encode()
+		urls = append(urls, u.String())
+	}
+
+	return urls, nil
+}
+
+// nextから続くページを総数から求め、最大concurrencyページを並列に取得して完了順にかかわらずページ順に返す。
+// その先は最後のページのnextでたどる。firstはnextのページ番号。
+// 失敗した場合はその手前までのページと、取得できなかった最初のページの番号を返す
+func fetchPagesConcurrently(ctx context.Context, config Config, manager *TokenManager, next string, total, first, concurrency int) ([]EpisodePage, int, error) {
+	urls, err := remainingPageURLs(next, total)
+	if err != nil {
+		return nil, first, err
+	}
+	// 総数より先にもページが続いている
+	if len(urls) == 0 {
+		urls = []string{next}
+	}
+	urls = urls[:min(len(urls), max(concurrency, 1))]
+
+	pages := make([]*EpisodePage, len(urls))
+	jobs := make(chan int)
+
+	// 1ページでも失敗すれば残りは使わないので取得をやめる
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	// 最初の失敗。他のページはその後のキャンセルで失敗しうる
+	var firstErr error
+
+	var wg sync.WaitGroup
+	for range len(urls) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				page, err := fetchPage(ctx, config, manager, urls[i], first+i)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					pages[i] = &page
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for i := range urls {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var fetched []EpisodePage
+	for i := range urls {
+		if pages[i] == nil {
+			err := firstErr
+			if err == nil {
+				err = ctx.Err()
+			}
+			return fetched, first + i, err
+		}
+		fetched = append(fetched, *pages[i])
+	}
+
+	return fetched, 0, nil
+}
+
+// 2ページ目以降を1ページ取得する。空のレスポンスはエピソード無しのページとみなす
+func fetchPage(ctx context.Context, config Config, manager *TokenManager, url string, i int) (EpisodePage, error) {
+	ctx, span := tracer.Start(ctx, "fetch page")
+	span.SetAttributes(attribute.String("url", url), attribute.Int("page", i))
+	defer span.End()
+
+	var page EpisodePage
+	body, err := GetProgramData(ctx, manager.client, config, manager, url)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return page, err
+	}
+	if body == nil {
+		return page, nil
+	}
+
+	err = json.Unmarshal(body, &page)
+	if err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// 番組のエピソードを1件ずつfnに渡す。全件をメモリに溜めずにページ単位で取得し、
+// fnがエラーを返したらそこで止めてそのエラーを返す
+func ForEachEpisode(ctx context.Context, manager *TokenManager, showID string, fn func(Item) error) error {
+	config := manager.config
+
+	body, err := GetProgramData(ctx, manager.client, config, manager, showURL(config, showID))
+	if err != nil {
+		return err
+	}
+
+	var pi ProgramInfo
+	if body != nil {
+		err = json.Unmarshal(body, &pi)
+		if err != nil {
+			return err
+		}
+	}
+
+	page := pi.Episodes
+	page.Next = withPageSize(page.Next, config.pageSize())
+	for i := 0; ; i++ {
+		for _, item := range page.Items {
+			item.ShowID = showID
+			err = fn(item)
+			if err != nil {
+				return err
+			}
+		}
+
+		if page.Next == "" {
+			return nil
+		}
+		if i+1 >= maxPages {
+			return fmt.Errorf("show %s still has a next page after %d pages", showID, maxPages)
+		}
+
+		page, err = fetchPage(ctx, config, manager, page.Next, i+1)
+		if err != nil {
+			return err
+		}
+	}
+}
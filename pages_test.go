@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFetchConcurrentPageOrder(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 41)
+	// 前のページほど遅く返して、完了順を逆にする
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil {
+			time.Sleep(time.Duration(41-offset) * time.Millisecond)
+		}
+		return false
+	}
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	manager := testTokenManager(spotify, config)
+
+	sequential, err := FetchItems(context.Background(), config, manager, testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sequential.Items) != 41 {
+		t.Fatalf("%d items fetched sequentially, want 41", len(sequential.Items))
+	}
+
+	for i := 0; i < 3; i++ {
+		concurrent, err := FetchItems(context.Background(), config, manager, testShowA, FetchOptions{Concurrency: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := itemIDs(concurrent.Items), itemIDs(sequential.Items); !reflect.DeepEqual(got, want) {
+			t.Fatalf("concurrent order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFetchTotalMismatch(t *testing.T) {
+	const warning = "fetched episode count differs from the reported total"
+
+	for _, tt := range []struct {
+		name  string
+		total int
+	}{
+		// 削除されたエピソードの分だけ総数が多い
+		{"total larger", 10},
+		// 総数の更新が遅れて少ない
+		{"total smaller", 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+			spotify := newFakeSpotify(t)
+			spotify.addShow(testShowA, 6).Info.TotalEpisodes = tt.total
+			config := testConfig(spotify, "")
+			config.PageSize = 2
+
+			result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// nextが無くなるまで取得する
+			if len(result.Items) != 6 {
+				t.Errorf("%d items fetched, want all 6", len(result.Items))
+			}
+			record := findRecord(slogRecords(t, logs.String()), "WARN", warning)
+			if record == nil || record["total"] != float64(tt.total) || record["fetched"] != float64(6) {
+				t.Errorf("warning = %v, want total %d and fetched 6", record, tt.total)
+			}
+		})
+	}
+}
+
+func TestFetchConcurrentStopsAtWindow(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	// 2024-01-20から2024-01-01まで1日1件
+	spotify.addShow(testShowA, 20)
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	window := DateWindow{From: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)}
+
+	result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{Window: window, Concurrency: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2024-01-14を含む4ページ目で止め、残りのページは取得しない
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")); n != 3 {
+		t.Errorf("%d page requests, want 3", n)
+	}
+	if len(result.Items) != 8 {
+		t.Errorf("%d items, want the 8 episodes of the first 4 pages", len(result.Items))
+	}
+}
+
+func TestFetchConcurrentFollowsNextPastTotal(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	// 総数の更新が遅れて、実際より少ない
+	spotify.addShow(testShowA, 10).Info.TotalEpisodes = 4
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+
+	result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{Concurrency: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 10 {
+		t.Errorf("%d items, want all 10", len(result.Items))
+	}
+}
+
+// nextが同じページを指し続けても上限で止まる
+func TestFetchStopsAtPageLimit(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/shows/"+testShowA+"/episodes" {
+			return false
+		}
+		writeJSON(w, EpisodePage{Items: []Item{{ID: "loop"}}, Next: spotify.URL + r.URL.RequestURI()})
+		return true
+	}
+	config := testConfig(spotify, "")
+
+	result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")); n != maxPages-1 {
+		t.Errorf("%d page requests, want %d", n, maxPages-1)
+	}
+	if len(result.Items) != 2+maxPages-1 {
+		t.Errorf("%d items, want %d", len(result.Items), 2+maxPages-1)
+	}
+}
+
+// 1ページ目(番組情報のepisodes)と2ページ目以降を同じEpisodePageとして読む
+func TestFirstAndNextPagesShareType(t *testing.T) {
+	first := []byte(`{"id": "show", "total_episodes": 3, "episodes": {
+		"href": "first", "items": [{"id": "ep3"}], "limit": 1, "next": "page2", "offset": 0, "total": 3
+	}}`)
+	next := []byte(`{"href": "page2", "items": [{"id": "ep2"}], "limit": 1, "next": "page3", "offset": 1, "previous": "first", "total": 3}`)
+
+	var pi ProgramInfo
+	if err := json.Unmarshal(first, &pi); err != nil {
+		t.Fatal(err)
+	}
+	var page EpisodePage
+	if err := json.Unmarshal(next, &page); err != nil {
+		t.Fatal(err)
+	}
+	want := EpisodePage{Href: "first", Items: []Item{{ID: "ep3"}}, Limit: 1, Next: "page2", Total: 3}
+	if !reflect.DeepEqual(pi.Episodes, want) {
+		t.Errorf("first page = %+v, want %+v", pi.Episodes, want)
+	}
+	want = EpisodePage{Href: "page2", Items: []Item{{ID: "ep2"}}, Limit: 1, Next: "page3", Offset: 1, Previous: "first", Total: 3}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("next page = %+v, want %+v", page, want)
+	}
+
+	// 1ページ目と続く2ページを通して取得する
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 6)
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+
+	result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := itemIDs(result.Items), []string{"1111-006", "1111-005", "1111-004", "1111-003", "1111-002", "1111-001"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")); n != 2 {
+		t.Errorf("%d next page requests, want 2", n)
+	}
+}
+
+func TestForEachEpisode(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 7)
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	manager := testTokenManager(spotify, config)
+
+	var ids []string
+	err := ForEachEpisode(context.Background(), manager, testShowA, func(item Item) error {
+		if item.ShowID != testShowA {
+			t.Errorf("%s has ShowID %q", item.ID, item.ShowID)
+		}
+		ids = append(ids, item.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1111-007", "1111-006", "1111-005", "1111-004", "1111-003", "1111-002", "1111-001"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("episodes = %v, want %v", ids, want)
+	}
+}
+
+func TestForEachEpisodeStopsEarly(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 7)
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	manager := testTokenManager(spotify, config)
+	errStop := errors.New("stop")
+
+	var n int
+	err := ForEachEpisode(context.Background(), manager, testShowA, func(item Item) error {
+		n++
+		if n == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("err = %v, want the callback's error", err)
+	}
+	if n != 3 {
+		t.Errorf("callback called %d times, want 3", n)
+	}
+	// 3件目は2ページ目にあるので、残りのページは取得しない
+	if requests := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")); requests != 1 {
+		t.Errorf("%d next page requests, want 1", requests)
+	}
+}
+
+func TestPageSizeAndMarketOnRequests(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 10)
+	config := testConfig(spotify, "")
+	config.PageSize = 3
+	config.Market = "JP"
+
+	_, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shows := spotify.requestsTo("/v1/shows/" + testShowA)
+	if len(shows) != 1 || shows[0].URL.Query().Get("market") != "JP" {
+		t.Errorf("show requests = %v, want one with market=JP", shows)
+	}
+	// 1ページ目の2件の後、3件ずつ
+	pages := spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")
+	if len(pages) != 3 {
+		t.Fatalf("%d next page requests, want 3", len(pages))
+	}
+	for _, page := range pages {
+		if limit := page.URL.Query().Get("limit"); limit != "3" {
+			t.Errorf("%s has limit %q, want 3", page.URL, limit)
+		}
+	}
+
+	for _, tt := range []struct{ size, want int }{{0, 50}, {-5, 1}, {20, 20}, {80, 50}} {
+		if got := (Config{PageSize: tt.size}).pageSize(); got != tt.want {
+			t.Errorf("pageSize() with %d = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// 書き込み前の確認用に一時的に使うテーブル
+func scratchTableName(tableName string) string {
+	return tableName + "-scratch"
+}
+
+// スクラッチテーブルと本番テーブルの差分
+type Plan struct {
+	Table   string
+	Added   []Item
+	Changed []Item
+	// 本番テーブルにだけあるエピソードのID。同期では削除しない
+	Stale []string
+}
+
+// 本番テーブルに書き込む必要のあるエピソード
+func (p Plan) Items() []Item {
+	items := make([]Item, 0, len(p.Added)+len(p.Changed))
+	items = append(items, p.Added...)
+	return append(items, p.Changed...)
+}
+
+func (p Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Plan for table %s: %d to add, %d to change, %d only in the table\n", p.Table, len(p.Added), len(p.Changed), len(p.Stale))
+	for _, item := range p.Added {
+		fmt.Fprintf(w, "  + %s %s\n", item.ID, item.Name)
+	}
+	for _, item := range p.Changed {
+		fmt.Fprintf(w, "  ~ %s %s\n", item.ID, item.Name)
+	}
+	for _, id := range p.Stale {
+		fmt.Fprintf(w, "  = %s\n", id)
+	}
+}
+
+// エピソードをスクラッチテーブルに書いて本番テーブルと比べる。
+// スクラッチテーブルは終了時に削除し、本番テーブルには書き込まない
+func PlanSync(ctx context.Context, config Config, items []Item) (Plan, error) {
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	// 言語別のテーブルは比べられない
+	if config.SplitByLanguage {
+		return Plan{}, errors.New("cannot plan a sync with split_by_language")
+	}
+
+	scratch := config
+	scratch.TableName = scratchTableName(config.tableName())
+
+	// 前回の残りがあれば消してから使う
+	err = DeleteTable(ctx, svc, scratch.TableName)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to clear scratch table %s: %w", scratch.TableName, err)
+	}
+	defer func() {
+		err := DeleteTable(context.WithoutCancel(ctx), svc, scratch.TableName)
+		if err != nil {
+			slog.Error("failed to delete scratch table", "table", scratch.TableName, "error", err)
+		}
+	}()
+
+	_, err = batchWriteItems(ctx, svc, scratch, items, WriteOptions{})
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to write scratch table %s: %w", scratch.TableName, err)
+	}
+
+	planned, err := scanByID(ctx, svc, scratch.TableName)
+	if err != nil {
+		return Plan{}, err
+	}
+	live, err := scanByID(ctx, svc, config.tableName())
+	if err != nil {
+		return Plan{}, err
+	}
+
+	return diffTables(config.tableName(), items, live, planned), nil
+}
+
+// テーブルの全件をIDをキーに読む。テーブルが無ければ空とみなす
+func scanByID(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string) (map[string]map[string]*dynamodb.AttributeValue, error) {
+	rows := make(map[string]map[string]*dynamodb.AttributeValue)
+
+	err := svc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, row := range page.Items {
+			rows[aws.StringValue(row["ID"].S)] = row
+		}
+		return true
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan table %s: %w", tableName, err)
+	}
+
+	return rows, nil
+}
+
+func diffTables(tableName string, items []Item, live, planned map[string]map[string]*dynamodb.AttributeValue) Plan {
+	plan := Plan{Table: tableName}
+
+	// バッチ書き込みと同じく同じIDは後のものを残す
+	byID := make(map[string]Item)
+	var ids []string
+	for _, item := range items {
+		if _, ok := byID[item.ID]; !ok {
+			ids = append(ids, item.ID)
+		}
+		byID[item.ID] = item
+	}
+
+	for _, id := range ids {
+		want, ok := planned[id]
+		if !ok {
+			continue
+		}
+
+		got, ok := live[id]
+		switch {
+		case !ok:
+			plan.Added = append(plan.Added, byID[id])
+		case !sameAttributes(got, want):
+			plan.Changed = append(plan.Changed, byID[id])
+		}
+	}
+
+	for id := range live {
+		if _, ok := planned[id]; !ok {
+			plan.Stale = append(plan.Stale, id)
+		}
+	}
+	sort.Strings(plan.Stale)
+
+	return plan
+}
+
+// 書き込み日時は毎回変わるので比較しない
+func sameAttributes(a, b map[string]*dynamodb.AttributeValue) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if key == "LastUpdated" {
+			continue
+		}
+		if !reflect.DeepEqual(value, b[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// y/yesが入力されたときだけtrue
+func confirm(r io.Reader, w io.Writer, prompt string) bool {
+	fmt.Fprintf(w, "%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
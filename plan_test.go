@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// 本番テーブルにep001, ep002, ep009を書いておく
+func seedLiveTable(t *testing.T, dynamo *fakeDynamoDB, config Config) {
+	t.Helper()
+
+	live := append(testItems(2), Item{ID: "ep009", Name: "Episode 9", ShowID: testShowA})
+	_, err := writeItems(context.Background(), dynamo, config, live, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storedNames(dynamo *fakeDynamoDB, tableName string) map[string]string {
+	names := make(map[string]string)
+	for _, item := range dynamo.items(tableName) {
+		names[aws.StringValue(item["ID"].S)] = aws.StringValue(item["Name"].S)
+	}
+	return names
+}
+
+func TestPlanSync(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	config := testConfig(nil, dynamo.serve(t))
+	seedLiveTable(t, dynamo, config)
+	before := storedNames(dynamo, config.tableName())
+
+	items := testItems(3)
+	items[1].Name = "Episode 2 (edited)"
+
+	plan, err := PlanSync(context.Background(), config, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := itemIDs(plan.Added); !reflect.DeepEqual(got, []string{"ep003"}) {
+		t.Errorf("Added = %v, want [ep003]", got)
+	}
+	if got := itemIDs(plan.Changed); !reflect.DeepEqual(got, []string{"ep002"}) {
+		t.Errorf("Changed = %v, want [ep002]", got)
+	}
+	if !reflect.DeepEqual(plan.Stale, []string{"ep009"}) {
+		t.Errorf("Stale = %v, want [ep009]", plan.Stale)
+	}
+
+	// スクラッチテーブルは作って消し、本番テーブルには書かない
+	if n := dynamo.countCalls("CreateTable"); n < 2 {
+		t.Errorf("%d CreateTable calls, want the scratch table created", n)
+	}
+	if dynamo.hasTable(scratchTableName(config.tableName())) {
+		t.Error("scratch table was not deleted")
+	}
+	if after := storedNames(dynamo, config.tableName()); !reflect.DeepEqual(after, before) {
+		t.Errorf("live table changed to %v, want %v", after, before)
+	}
+}
+
+func TestFetchPlanConfirm(t *testing.T) {
+	for _, answer := range []string{"n", "y"} {
+		t.Run(answer, func(t *testing.T) {
+			spotify := newFakeSpotify(t)
+			spotify.addShow(testShowA, 2)
+			dynamo := newFakeDynamoDB()
+			config := testConfig(spotify, dynamo.serve(t))
+			dynamo.seed(config.tableName(), map[string]*dynamodb.AttributeValue{
+				"ID":   {S: aws.String("1111-001")},
+				"Name": {S: aws.String("old name")},
+			})
+			withStdin(t, answer+"\n")
+
+			var code int
+			out := captureStdout(t, func() { code = runCommand(t, config, "fetch", "-plan", "-show", testShowA) })
+			if code != 0 {
+				t.Fatalf("exit code = %d, want 0", code)
+			}
+			if !strings.Contains(out, "1 to add, 1 to change") {
+				t.Errorf("plan not printed:\n%s", out)
+			}
+
+			want := map[string]string{"1111-001": "old name"}
+			if answer == "y" {
+				want = map[string]string{"1111-001": "Episode 1", "1111-002": "Episode 2"}
+			}
+			if got := storedNames(dynamo, config.tableName()); !reflect.DeepEqual(got, want) {
+				t.Errorf("live table = %v, want %v", got, want)
+			}
+			if dynamo.hasTable(scratchTableName(config.tableName())) {
+				t.Error("scratch table was not deleted")
+			}
+		})
+	}
+}
+
+func TestFetchPlanRejectsSplitByLanguage(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 2)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.SplitByLanguage = true
+
+	code := runCommand(t, config, "fetch", "-plan", "-show", testShowA)
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if n := len(spotify.requests); n != 0 {
+		t.Errorf("%d requests to Spotify, want none", n)
+	}
+	if n := dynamo.countCalls("CreateTable"); n != 0 {
+		t.Errorf("CreateTable called %d times, want 0", n)
+	}
+
+	_, err := PlanSync(context.Background(), config, testItems(1))
+	if err == nil {
+		t.Error("PlanSync() with split_by_language succeeded, want an error")
+	}
+}
+
+// テストの間os.Stdinからinputを読ませる
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.WriteString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+	})
+}
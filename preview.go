@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type PreviewFailure struct {
+	Item   Item
+	Status int
+	Err    error
+}
+
+func (f PreviewFailure) String() string {
+	if f.Err != nil {
+		return fmt.Sprintf("%s (%s): %v", f.Item.Name, f.Item.AudioPreviewURL, f.Err)
+	}
+	return fmt.Sprintf("%s (%s): status %d", f.Item.Name, f.Item.AudioPreviewURL, f.Status)
+}
+
+// プレビュー音声のURLにconcurrency並列、毎秒perSecond件までHEADリクエストを送り、
+// 到達できなかったものを元の順序で返す。ctxが終われば残りは確認しない
+func CheckPreviews(ctx context.Context, items []Item, concurrency int, perSecond int) []PreviewFailure {
+	concurrency = max(concurrency, 1)
+	perSecond = max(perSecond, 1)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(time.Second / time.Duration(perSecond))
+	defer ticker.Stop()
+
+	results := make([]*PreviewFailure, len(items))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = checkPreview(ctx, client, items[i])
+			}
+		}()
+	}
+
+dispatch:
+	for i, item := range items {
+		if item.AudioPreviewURL == "" {
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break dispatch
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var failures []PreviewFailure
+	for _, result := range results {
+		if result != nil {
+			failures = append(failures, *result)
+		}
+	}
+
+	return failures
+}
+
+func checkPreview(ctx context.Context, client *http.Client, item Item) *PreviewFailure {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, item.AudioPreviewURL, nil)
+	if err != nil {
+		return &PreviewFailure{Item: item, Err: err}
+	}
+
+	resp, err := client.Do(req)
+	// 中断された確認は到達できなかったものとして報告しない
+	if ctx.Err() != nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil
+	}
+	if err != nil {
+		return &PreviewFailure{Item: item, Err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return &PreviewFailure{Item: item, Status: resp.StatusCode}
+	}
+
+	return nil
+}
+
+// 番組ごとにまとめて表示する
+func PrintPreviewReport(w io.Writer, failures []PreviewFailure) {
+	var shows []string
+	byShow := make(map[string][]PreviewFailure)
+	for _, failure := range failures {
+		showID := failure.Item.ShowID
+		if _, ok := byShow[showID]; !ok {
+			shows = append(shows, showID)
+		}
+		byShow[showID] = append(byShow[showID], failure)
+	}
+
+	if len(failures) == 0 {
+		fmt.Fprintln(w, "Unreachable previews: 0")
+	}
+	for _, showID := range shows {
+		fmt.Fprintf(w, "Unreachable previews for show %s: %d\n", showID, len(byShow[showID]))
+		for _, failure := range byShow[showID] {
+			fmt.Fprintf(w, "  %s\n", failure)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckPreviewsReportsNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	items := []Item{
+		{ID: "ok1", Name: "OK 1", AudioPreviewURL: server.URL + "/ok1", ShowID: testShowA},
+		{ID: "missing1", Name: "Missing 1", AudioPreviewURL: server.URL + "/missing1", ShowID: testShowA},
+		{ID: "nopreview", Name: "No preview", ShowID: testShowA},
+		{ID: "missing2", Name: "Missing 2", AudioPreviewURL: server.URL + "/missing2", ShowID: testShowB},
+		{ID: "ok2", Name: "OK 2", AudioPreviewURL: server.URL + "/ok2", ShowID: testShowB},
+	}
+
+	failures := CheckPreviews(context.Background(), items, 2, 1000)
+
+	var ids []string
+	for _, failure := range failures {
+		ids = append(ids, failure.Item.ID)
+		if failure.Status != http.StatusNotFound {
+			t.Errorf("%s status = %d, want 404", failure.Item.ID, failure.Status)
+		}
+	}
+	if want := []string{"missing1", "missing2"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("failures = %v, want %v", ids, want)
+	}
+
+	var out bytes.Buffer
+	PrintPreviewReport(&out, failures)
+	report := out.String()
+	for _, want := range []string{"show " + testShowA + ": 1", "show " + testShowB + ": 1", "Missing 1", "Missing 2"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "OK ") || strings.Contains(report, "No preview") {
+		t.Errorf("report lists reachable episodes:\n%s", report)
+	}
+}
+
+func TestCheckPreviewsStopsOnCancel(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		// 応答する前に中断される
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	var items []Item
+	for i := range 5 {
+		items = append(items, Item{ID: fmt.Sprintf("ep%d", i), AudioPreviewURL: fmt.Sprintf("%s/ep%d", server.URL, i)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan []PreviewFailure)
+	go func() { done <- CheckPreviews(ctx, items, 1, 1000) }()
+
+	select {
+	case failures := <-done:
+		if len(failures) != 0 {
+			t.Errorf("failures = %v, want none for canceled checks", failures)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CheckPreviews did not return after the context was canceled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 1 {
+		t.Errorf("%d requests, want 1 before the cancel", requests)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// CPUプロファイルを開始し、終了時に呼ぶ関数を返す。memPathがあればその時点のヒーププロファイルも書く
+func StartProfile(cpuPath, memPath string) (func() error, error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		var err error
+		cpuFile, err = os.Create(cpuPath)
+		if err != nil {
+			return nil, err
+		}
+
+		err = pprof.StartCPUProfile(cpuFile)
+		if err != nil {
+			cpuFile.Close()
+			return nil, err
+		}
+	}
+
+	return func() error {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			err := cpuFile.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		if memPath != "" {
+			memFile, err := os.Create(memPath)
+			if err != nil {
+				return err
+			}
+			defer memFile.Close()
+
+			runtime.GC()
+			return pprof.WriteHeapProfile(memFile)
+		}
+
+		return nil
+	}, nil
+}
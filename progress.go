@@ -0,0 +1,31 @@
+package main
+
+type ProgressStage string
+
+const (
+	ProgressFetch ProgressStage = "fetch"
+	ProgressWrite ProgressStage = "write"
+)
+
+// 取得・書き込みの進捗。アプリに組み込む場合にチャネルで受け取る
+type Progress struct {
+	Stage  ProgressStage
+	ShowID string
+	// 取得したページ数(fetch)
+	Pages int
+	// これまでに処理したエピソード数と全体の件数
+	Done  int
+	Total int
+}
+
+// 受け手が詰まっていても同期を止めないよう、送れないときは捨てる
+func sendProgress(progress chan<- Progress, p Progress) {
+	if progress == nil {
+		return
+	}
+
+	select {
+	case progress <- p:
+	default:
+	}
+}
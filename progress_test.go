@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func receiveProgress(progress chan Progress) []Progress {
+	close(progress)
+	var events []Progress
+	for p := range progress {
+		events = append(events, p)
+	}
+	return events
+}
+
+func TestProgressCoversFetchAndWrite(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 6)
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+	ctx := context.Background()
+
+	progress := make(chan Progress, 100)
+	result, err := FetchItems(ctx, config, testTokenManager(spotify, config), testShowA, FetchOptions{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Progress{
+		{Stage: ProgressFetch, ShowID: testShowA, Pages: 1, Done: 2, Total: 6},
+		{Stage: ProgressFetch, ShowID: testShowA, Pages: 2, Done: 4, Total: 6},
+		{Stage: ProgressFetch, ShowID: testShowA, Pages: 3, Done: 6, Total: 6},
+	}
+	if got := receiveProgress(progress); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetch progress = %+v, want %+v", got, want)
+	}
+
+	// 1件ずつ書く経路
+	progress = make(chan Progress, 100)
+	_, err = writeItems(ctx, newFakeDynamoDB(), Config{NoClobber: true}, result.Items, WriteOptions{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = nil
+	for i := 1; i <= 6; i++ {
+		want = append(want, Progress{Stage: ProgressWrite, ShowID: testShowA, Done: i, Total: 6})
+	}
+	if got := receiveProgress(progress); !reflect.DeepEqual(got, want) {
+		t.Errorf("write progress = %+v, want %+v", got, want)
+	}
+
+	// まとめて書く経路ではバッチごとに送る
+	progress = make(chan Progress, 100)
+	_, err = writeItems(ctx, newFakeDynamoDB(), Config{}, testItems(30), WriteOptions{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Progress{
+		{Stage: ProgressWrite, Done: 25, Total: 30},
+		{Stage: ProgressWrite, Done: 30, Total: 30},
+	}
+	if got := receiveProgress(progress); !reflect.DeepEqual(got, want) {
+		t.Errorf("batch write progress = %+v, want %+v", got, want)
+	}
+}
+
+// 受け手がいなくても同期は止まらない
+func TestProgressDoesNotBlock(t *testing.T) {
+	progress := make(chan Progress)
+	_, err := writeItems(context.Background(), newFakeDynamoDB(), Config{}, testItems(30), WriteOptions{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrItemNotFound = errors.New("episode not found")
+
+// テーブルからIDでエピソードを1件読む
+func GetItem(ctx context.Context, config Config, id string) (Item, error) {
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return Item{}, err
+	}
+
+	return getItem(ctx, svc, config.tableName(), id)
+}
+
+func getItem(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName, id string) (Item, error) {
+	output, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {S: aws.String(id)},
+		},
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	if len(output.Item) == 0 {
+		return Item{}, fmt.Errorf("%w: %s", ErrItemNotFound, id)
+	}
+
+	return attributesToItem(output.Item)
+}
+
+// テーブルの全エピソードを読む。LastEvaluatedKeyをたどって全ページを返す
+func Scan(ctx context.Context, config Config) ([]Item, error) {
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanItems(ctx, svc, config.tableName())
+}
+
+func scanItems(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string) ([]Item, error) {
+	var items []Item
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}
+
+	for {
+		output, err := svc.ScanWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attributes := range output.Items {
+			item, err := attributesToItem(attributes)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+
+		if len(output.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// テーブルにある番組ごとのエピソード数
+type ShowCount struct {
+	ShowID   string
+	Name     string
+	Episodes int
+}
+
+// エピソードを番組IDごとに数える。番組名はShowテーブルに記録があれば入れる
+func CountShows(items []Item, shows map[string]map[string]*dynamodb.AttributeValue) []ShowCount {
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[item.ShowID]++
+	}
+
+	var result []ShowCount
+	for showID, n := range counts {
+		count := ShowCount{ShowID: showID, Episodes: n}
+		if show, ok := shows[showID]; ok && show["Name"] != nil {
+			count.Name = aws.StringValue(show["Name"].S)
+		}
+		result = append(result, count)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ShowID < result[j].ShowID })
+	return result
+}
+
+// 番組IDを記録していない古いエピソードは - として数える
+func PrintShowCounts(w io.Writer, counts []ShowCount) {
+	for _, count := range counts {
+		showID := count.ShowID
+		if showID == "" {
+			showID = "-"
+		}
+		fmt.Fprintf(w, "%-22s  %6d  %s\n", showID, count.Episodes, count.Name)
+	}
+}
+
+func PrintItems(w io.Writer, items []Item) {
+	for _, item := range items {
+		fmt.Fprintf(w, "%-10s  %s\n", item.ReleaseDate, item.Name)
+	}
+}
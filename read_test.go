@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestScanFollowsLastEvaluatedKey(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	// 1件ずつ返してLastEvaluatedKeyをたどらせる
+	dynamo.scanPageSize = 1
+	config := testConfig(nil, dynamo.serve(t))
+	for _, id := range []string{"ep1", "ep2", "ep3"} {
+		dynamo.seed(config.tableName(), map[string]*dynamodb.AttributeValue{
+			"ID":          {S: aws.String(id)},
+			"Name":        {S: aws.String("Episode " + id)},
+			"ReleaseDate": {S: aws.String("2024-01-01")},
+		})
+	}
+	ctx := context.Background()
+
+	items, err := Scan(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := itemIDs(items)
+	sort.Strings(got)
+	if want := []string{"ep1", "ep2", "ep3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned IDs = %v, want %v", got, want)
+	}
+	if n := dynamo.countCalls("Scan"); n != 3 {
+		t.Errorf("%d Scan calls, want one per page", n)
+	}
+
+	item, err := GetItem(ctx, config, "ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Name != "Episode ep2" {
+		t.Errorf("GetItem() name = %q, want Episode ep2", item.Name)
+	}
+	if _, err := GetItem(ctx, config, "missing"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("GetItem() of a missing episode = %v, want ErrItemNotFound", err)
+	}
+}
+
+func TestListShows(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	spotify.addShow(testShowB, 2)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.RecordShows = true
+
+	if code := runCommand(t, config, "fetch", "-show", testShowA+","+testShowB); code != 0 {
+		t.Fatalf("fetch exit code = %d, want 0", code)
+	}
+
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := scanItems(context.Background(), svc, config.tableName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := scanByID(context.Background(), svc, config.showTableName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ShowCount{
+		{ShowID: testShowA, Name: "Show " + testShowA[:4], Episodes: 3},
+		{ShowID: testShowB, Name: "Show " + testShowB[:4], Episodes: 2},
+	}
+	if got := CountShows(items, names); !reflect.DeepEqual(got, want) {
+		t.Errorf("CountShows() = %+v, want %+v", got, want)
+	}
+
+	var code int
+	out := captureStdout(t, func() { code = runCommand(t, config, "list", "-shows") })
+	if code != 0 {
+		t.Fatalf("list -shows exit code = %d, want 0", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("list -shows printed %d lines, want 2:\n%s", len(lines), out)
+	}
+	for i, fields := range [][]string{{testShowA, "3"}, {testShowB, "2"}} {
+		if got := strings.Fields(lines[i]); len(got) < 2 || got[0] != fields[0] || got[1] != fields[1] {
+			t.Errorf("line %d = %q, want %s with %s episodes", i, lines[i], fields[0], fields[1])
+		}
+	}
+}
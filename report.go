@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 1回の実行の集計
+type RunStats struct {
+	Shows     []string      `json:"shows"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Fetched   int           `json:"fetched"`
+	Written   int           `json:"written"`
+	New       int           `json:"new"`
+	Skipped   int           `json:"skipped"`
+	Partial   bool          `json:"partial"`
+	Unchanged int           `json:"unchanged"`
+	// 失敗した場合のエラー。-quiet-errorsで終了コードが0でも残る
+	Error string `json:"error,omitempty"`
+}
+
+// 実行の最後にRunStatsを受け取る
+type ReportSink interface {
+	Report(ctx context.Context, stats RunStats) error
+}
+
+type StdoutJSONSink struct {
+	W io.Writer
+}
+
+func (s StdoutJSONSink) Report(ctx context.Context, stats RunStats) error {
+	return json.NewEncoder(s.W).Encode(stats)
+}
+
+type NopSink struct{}
+
+func (NopSink) Report(ctx context.Context, stats RunStats) error {
+	return nil
+}
+
+var reportSinks = map[string]ReportSink{
+	"stdout-json": StdoutJSONSink{W: os.Stdout},
+	"noop":        NopSink{},
+}
+
+// 独自のReportSinkを -report で選べるように登録する
+func RegisterReportSink(name string, sink ReportSink) {
+	reportSinks[name] = sink
+}
+
+func LookupReportSink(name string) (ReportSink, error) {
+	sink, ok := reportSinks[name]
+	if !ok {
+		var names []string
+		for name := range reportSinks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown report sink %q (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	return sink, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFetchReportsStats(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 5)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	sink := registerCapturingSink(t, "capture")
+
+	code := runCommand(t, config, "fetch", "-show", testShowA, "-report", "capture")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	stats := sink.last(t)
+	if !reflect.DeepEqual(stats.Shows, []string{testShowA}) {
+		t.Errorf("Shows = %v, want [%s]", stats.Shows, testShowA)
+	}
+	if stats.Fetched != 5 || stats.Written != 5 {
+		t.Errorf("Fetched, Written = %d, %d, want 5, 5", stats.Fetched, stats.Written)
+	}
+	if stats.StartedAt.IsZero() || stats.Duration <= 0 {
+		t.Errorf("StartedAt, Duration = %v, %v, want both set", stats.StartedAt, stats.Duration)
+	}
+	if stats.Error != "" {
+		t.Errorf("Error = %q, want empty", stats.Error)
+	}
+}
+
+func TestLookupReportSink(t *testing.T) {
+	sink := registerCapturingSink(t, "capture")
+
+	got, err := LookupReportSink("capture")
+	if err != nil || got != sink {
+		t.Errorf("LookupReportSink(capture) = %v, %v, want the registered sink", got, err)
+	}
+	if _, err := LookupReportSink("missing"); err == nil {
+		t.Error("LookupReportSink(missing) succeeded, want an error")
+	}
+}
+
+// -report stdout-jsonの出力に他の行が混ざらないよう、書き込みは標準出力に何も出さない
+func TestFetchWritesNothingToStdout(t *testing.T) {
+	for _, noClobber := range []bool{false, true} {
+		spotify := newFakeSpotify(t)
+		spotify.addShow(testShowA, 3)
+		dynamo := newFakeDynamoDB()
+		config := testConfig(spotify, dynamo.serve(t))
+		config.NoClobber = noClobber
+
+		var code int
+		out := captureStdout(t, func() { code = runCommand(t, config, "fetch", "-show", testShowA) })
+		if code != 0 {
+			t.Fatalf("NoClobber %t: exit code = %d, want 0", noClobber, code)
+		}
+		if out != "" {
+			t.Errorf("NoClobber %t: stdout = %q, want nothing", noClobber, out)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxRetries       = 3
+	defaultRetryBaseDelayMs = 500
+)
+
+func (c Config) maxRetries() int {
+	if c.MaxRetries <= 0 {
+		return defaultMaxRetries
+	}
+	return c.MaxRetries
+}
+
+func (c Config) retryBaseDelay() time.Duration {
+	if c.RetryBaseDelayMs <= 0 {
+		return defaultRetryBaseDelayMs * time.Millisecond
+	}
+	return time.Duration(c.RetryBaseDelayMs) * time.Millisecond
+}
+
+// 指数バックオフの待ち時間dをばらつかせる方法
+var jitterStrategies = map[string]func(d time.Duration) time.Duration{
+	"none":  noJitter,
+	"full":  fullJitter,
+	"equal": equalJitter,
+}
+
+const defaultRetryJitter = "equal"
+
+// そのまま待つ
+func noJitter(d time.Duration) time.Duration {
+	return d
+}
+
+// 0〜dの間でばらつかせる
+func fullJitter(d time.Duration) time.Duration {
+	return rand.N(d + 1)
+}
+
+// 半分は必ず待ち、残りの半分をばらつかせる
+func equalJitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+func (c Config) ValidateRetryJitter() error {
+	if _, ok := jitterStrategies[c.RetryJitter]; c.RetryJitter != "" && !ok {
+		return fmt.Errorf("unknown retry_jitter %q (want none, full or equal)", c.RetryJitter)
+	}
+	return nil
+}
+
+// attempt回目(0始まり)のリトライまでの待ち時間
+func (c Config) retryBackoff(attempt int) time.Duration {
+	jitter, ok := jitterStrategies[c.RetryJitter]
+	if !ok {
+		jitter = jitterStrategies[defaultRetryJitter]
+	}
+	return jitter(c.retryBaseDelay() << attempt)
+}
+
+var ErrRateLimited = errors.New("rate limited")
+
+// 429のリトライを使い切ったときのエラー。errors.Is(err, ErrRateLimited) で判定できる
+type RateLimitError struct {
+	URL        string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited on %s (retry after %s)", e.URL, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// 429ならRetry-Afterの秒数、5xxなら指数バックオフ(retry_jitterのジッター付き)で待つ
+func retryDelay(config Config, resp *http.Response, attempt int) time.Duration {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if delay, ok := retryAfter(resp); ok {
+			return delay
+		}
+	}
+
+	return config.retryBackoff(attempt)
+}
+
+func isRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// 429と5xxのレスポンスをリトライする。最後のレスポンスはそのまま返すが、
+// 429が続いた場合は*RateLimitErrorを返す
+func doWithRetry(config Config, client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isRetryable(resp.StatusCode) {
+			return resp, nil
+		}
+		if attempt >= config.maxRetries() {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				delay, _ := retryAfter(resp)
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				return nil, &RateLimitError{URL: req.URL.String(), RetryAfter: delay}
+			}
+			return resp, nil
+		}
+
+		delay := retryDelay(config, resp, attempt)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		slog.Warn("request failed, retrying", "url", req.URL.String(), "status", resp.StatusCode, "delay", delay, "attempt", attempt+1, "max_retries", config.maxRetries())
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		// POSTのボディは読み切っているので作り直す
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 番組情報への最初のn回のリクエストにstatusを返す
+func failShowRequests(spotify *fakeSpotify, n, status int, header http.Header) {
+	var mu sync.Mutex
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/shows/"+testShowA {
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if n == 0 {
+			return false
+		}
+		n--
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		http.Error(w, http.StatusText(status), status)
+		return true
+	}
+}
+
+func TestRetryRateLimitThenSuccess(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 1)
+	failShowRequests(spotify, 2, http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}})
+	config := testConfig(spotify, "")
+	config.MaxRetries = 3
+
+	body, err := GetProgramData(context.Background(), spotify.Client(), config, testTokenManager(spotify, config), showURL(config, testShowA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) == 0 {
+		t.Error("empty body after retrying")
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA)); n != 3 {
+		t.Errorf("%d show requests, want 3", n)
+	}
+}
+
+func TestRetryServerErrorThenSuccess(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 1)
+	failShowRequests(spotify, 2, http.StatusServiceUnavailable, nil)
+	config := testConfig(spotify, "")
+	config.MaxRetries = 3
+
+	_, err := GetProgramData(context.Background(), spotify.Client(), config, testTokenManager(spotify, config), showURL(config, testShowA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA)); n != 3 {
+		t.Errorf("%d show requests, want 3", n)
+	}
+
+	// 回数ごとに倍になり、ジッターで半分から全体の間に収まる
+	config.RetryBaseDelayMs = 100
+	resp := &http.Response{StatusCode: http.StatusInternalServerError}
+	for attempt := 0; attempt < 4; attempt++ {
+		backoff := 100 * time.Millisecond << attempt
+		for i := 0; i < 20; i++ {
+			delay := retryDelay(config, resp, attempt)
+			if delay < backoff/2 || delay > backoff {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", attempt, delay, backoff/2, backoff)
+			}
+		}
+	}
+
+	// 429はRetry-Afterに従う
+	resp = &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"7"}}}
+	if delay := retryDelay(config, resp, 0); delay != 7*time.Second {
+		t.Errorf("429 delay = %s, want 7s", delay)
+	}
+}
+
+func TestRetryRateLimitExhausted(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 1)
+	failShowRequests(spotify, 100, http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}})
+	config := testConfig(spotify, "")
+	config.MaxRetries = 2
+	manager := testTokenManager(spotify, config)
+	url := showURL(config, testShowA)
+
+	_, err := GetProgramData(context.Background(), spotify.Client(), config, manager, url)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) || rateLimitErr.URL != url {
+		t.Errorf("err = %#v, want a RateLimitError for %s", err, url)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA)); n != 3 {
+		t.Errorf("%d show requests, want 3", n)
+	}
+
+	// 最後のレスポンスのRetry-Afterを返す。使い切った後は待たない
+	spotify = newFakeSpotify(t)
+	spotify.addShow(testShowA, 1)
+	retryAfters := []string{"0", "120"}
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/shows/"+testShowA {
+			return false
+		}
+		w.Header().Set("Retry-After", retryAfters[0])
+		retryAfters = retryAfters[1:]
+		w.WriteHeader(http.StatusTooManyRequests)
+		return true
+	}
+	config = testConfig(spotify, "")
+	config.MaxRetries = 1
+
+	start := time.Now()
+	_, err = GetProgramData(context.Background(), spotify.Client(), config, testTokenManager(spotify, config), showURL(config, testShowA))
+	if !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 120*time.Second {
+		t.Errorf("err = %v, want a RateLimitError with Retry-After 2m0s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("waited %s after the last 429", elapsed)
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	const attempt = 3
+	// 100ms << 3
+	const backoff = 800 * time.Millisecond
+
+	tests := []struct {
+		jitter   string
+		min, max time.Duration
+	}{
+		{"none", backoff, backoff},
+		{"full", 0, backoff},
+		{"equal", backoff / 2, backoff},
+		{"", backoff / 2, backoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.jitter, func(t *testing.T) {
+			config := Config{RetryBaseDelayMs: 100, RetryJitter: tt.jitter}
+			for range 1000 {
+				if d := config.retryBackoff(attempt); d < tt.min || d > tt.max {
+					t.Fatalf("retryBackoff(%d) = %s, want between %s and %s", attempt, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+
+	if err := (Config{RetryJitter: "random"}).ValidateRetryJitter(); err == nil {
+		t.Error("ValidateRetryJitter() accepted an unknown strategy")
+	}
+}
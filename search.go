@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+type searchResponse struct {
+	Shows struct {
+		// 該当が無い位置にnullが入ることがある
+		Items []*ProgramInfo `json:"items"`
+	} `json:"shows"`
+}
+
+// 番組名などで番組を検索する。limitはSpotifyの1〜50に丸める
+func SearchShows(ctx context.Context, manager *TokenManager, query string, limit int) ([]ProgramInfo, error) {
+	limit = min(max(limit, 1), 50)
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("type", "show")
+	params.Set("limit", strconv.Itoa(limit))
+
+	body, err := GetProgramData(ctx, manager.client, manager.config, manager, spotifyAPI+"/search?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, nil
+	}
+
+	var response searchResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	var shows []ProgramInfo
+	for _, show := range response.Shows.Items {
+		if show != nil {
+			shows = append(shows, *show)
+		}
+	}
+
+	return shows, nil
+}
+
+func PrintShows(w io.Writer, shows []ProgramInfo) {
+	if len(shows) == 0 {
+		fmt.Fprintln(w, "No shows found")
+		return
+	}
+
+	for _, show := range shows {
+		fmt.Fprintf(w, "%s  %s (%s)\n", show.ID, show.Name, show.Publisher)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSearchShows(t *testing.T) {
+	const query = "rock & roll radio/ラジオ"
+
+	spotify := newFakeSpotify(t)
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/search" {
+			return false
+		}
+		if r.URL.Query().Get("q") != query {
+			w.Write([]byte(`{"shows":{"items":[]}}`))
+			return true
+		}
+		w.Write([]byte(`{"shows":{"items":[
+			{"id":"` + testShowA + `","name":"Rock & Roll Radio","publisher":"Publisher A"},
+			null,
+			{"id":"` + testShowB + `","name":"Radio","publisher":"Publisher B"}
+		]}}`))
+		return true
+	}
+	config := testConfig(spotify, "")
+	manager := testTokenManager(spotify, config)
+
+	shows, err := SearchShows(context.Background(), manager, query, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shows) != 2 || shows[0].ID != testShowA || shows[0].Name != "Rock & Roll Radio" || shows[1].ID != testShowB {
+		t.Errorf("shows = %+v, want %s and %s", shows, testShowA, testShowB)
+	}
+
+	requests := spotify.requestsTo("/v1/search")
+	if len(requests) != 1 {
+		t.Fatalf("%d search requests, want 1", len(requests))
+	}
+	params := requests[0].URL.Query()
+	if params.Get("type") != "show" || params.Get("limit") != "5" {
+		t.Errorf("search parameters = %v", params)
+	}
+	if raw := requests[0].URL.RawQuery; strings.ContainsAny(raw, " /") || strings.Contains(raw, "& ") {
+		t.Errorf("query not encoded: %s", raw)
+	}
+
+	// 見つからなくてもエラーにしない
+	shows, err = SearchShows(context.Background(), manager, "nothing", 5)
+	if err != nil || len(shows) != 0 {
+		t.Errorf("SearchShows() = %+v, %v, want no shows", shows, err)
+	}
+	var out strings.Builder
+	PrintShows(&out, shows)
+	if out.String() != "No shows found\n" {
+		t.Errorf("PrintShows() = %q", out.String())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const defaultShowTableName = "Show"
+
+// 番組の情報を書くテーブル
+func (c Config) showTableName() string {
+	if c.ShowTableName == "" {
+		return defaultShowTableName
+	}
+	return c.ShowTableName
+}
+
+// 番組用のテーブル。番組IDがキー
+func EnsureShowTable(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string) error {
+	return EnsureTable(ctx, svc, tableName)
+}
+
+func showToAttributes(pi ProgramInfo, lastUpdated string) map[string]*dynamodb.AttributeValue {
+	attributes := map[string]*dynamodb.AttributeValue{
+		"ID": {
+			S: aws.String(pi.ID),
+		},
+		"Name": {
+			S: aws.String(pi.Name),
+		},
+		"Publisher": {
+			S: aws.String(pi.Publisher),
+		},
+		"Description": {
+			S: aws.String(pi.Description),
+		},
+		"TotalEpisodes": {
+			N: aws.String(strconv.Itoa(pi.TotalEpisodes)),
+		},
+		"Explicit": {
+			BOOL: aws.Bool(pi.Explicit),
+		},
+		"IsExternallyHosted": {
+			BOOL: aws.Bool(pi.IsExternallyHosted),
+		},
+		"LastUpdated": {
+			S: aws.String(lastUpdated),
+		},
+	}
+
+	if pi.MediaType != "" {
+		attributes["MediaType"] = &dynamodb.AttributeValue{
+			S: aws.String(pi.MediaType),
+		}
+	}
+	if pi.ExternalUrls.Spotify != "" {
+		attributes["SpotifyURL"] = &dynamodb.AttributeValue{
+			S: aws.String(pi.ExternalUrls.Spotify),
+		}
+	}
+	if len(pi.Images) > 0 {
+		attributes["Images"] = imagesToAttribute(pi.Images)
+	}
+	if len(pi.Languages) > 0 {
+		attributes["Languages"] = &dynamodb.AttributeValue{
+			SS: aws.StringSlice(pi.Languages),
+		}
+	}
+
+	return attributes
+}
+
+// 番組の情報をShowテーブルに書く。既存の番組は上書きする
+func PutShow(ctx context.Context, svc dynamodbiface.DynamoDBAPI, config Config, pi ProgramInfo) error {
+	err := EnsureShowTable(ctx, svc, config.showTableName())
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(config.showTableName()),
+		Item:      showToAttributes(pi, time.Now().UTC().Format(time.RFC3339)),
+	})
+
+	return err
+}
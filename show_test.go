@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestPutShow(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	config := Config{}
+	pi := ProgramInfo{ID: testShowA, Name: "Show A", Publisher: "Publisher A", TotalEpisodes: 120, Images: []Image{{URL: "https://i.scdn.co/image/a", Height: 64, Width: 64}}}
+
+	err := PutShow(context.Background(), dynamo, config, pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := dynamo.items(defaultShowTableName)
+	if len(rows) != 1 {
+		t.Fatalf("%d show records, want 1", len(rows))
+	}
+	row := rows[0]
+	if id := aws.StringValue(row["ID"].S); id != testShowA {
+		t.Errorf("ID = %q, want %s", id, testShowA)
+	}
+	if publisher := aws.StringValue(row["Publisher"].S); publisher != "Publisher A" {
+		t.Errorf("Publisher = %q, want Publisher A", publisher)
+	}
+	if total := aws.StringValue(row["TotalEpisodes"].N); total != "120" {
+		t.Errorf("TotalEpisodes = %q, want 120", total)
+	}
+	if row["Images"] == nil || len(row["Images"].L) != 1 {
+		t.Errorf("Images = %v, want one image", row["Images"])
+	}
+}
+
+func TestFetchWritesShow(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	// record_showsが無ければ書かない
+	if code := runCommand(t, config, "fetch", "-show", testShowA); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if dynamo.hasTable(defaultShowTableName) {
+		t.Error("show table written without record_shows")
+	}
+
+	config.RecordShows = true
+	if code := runCommand(t, config, "fetch", "-show", testShowA); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	rows := dynamo.items(defaultShowTableName)
+	if len(rows) != 1 {
+		t.Fatalf("%d show records, want 1", len(rows))
+	}
+	if publisher, total := aws.StringValue(rows[0]["Publisher"].S), aws.StringValue(rows[0]["TotalEpisodes"].N); publisher != "Publisher" || total != "3" {
+		t.Errorf("Publisher, TotalEpisodes = %q, %q, want Publisher, 3", publisher, total)
+	}
+}
+
+func TestPutShowCustomTableName(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	config := Config{ShowTableName: "DevShows"}
+
+	err := PutShow(context.Background(), dynamo, config, ProgramInfo{ID: testShowA, Publisher: "Publisher A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(dynamo.items("DevShows")); n != 1 {
+		t.Errorf("%d show records in DevShows, want 1", n)
+	}
+	if dynamo.hasTable(defaultShowTableName) {
+		t.Errorf("default table %s was created", defaultShowTableName)
+	}
+}
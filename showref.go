@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// SpotifyのIDは22文字のbase62
+var spotifyIDPattern = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+// 番組ID、spotify:show:ID、open.spotify.com/show/ID のいずれかからIDを取り出す
+func ParseShowRef(s string) (string, error) {
+	ref := strings.TrimSpace(s)
+
+	var id string
+	switch {
+	case strings.HasPrefix(ref, "spotify:"):
+		parts := strings.Split(ref, ":")
+		if len(parts) != 3 || parts[1] != "show" {
+			return "", fmt.Errorf("not a Spotify show URI: %q", s)
+		}
+		id = parts[2]
+	case strings.Contains(ref, "open.spotify.com"):
+		if !strings.Contains(ref, "://") {
+			ref = "https://" + ref
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("invalid Spotify show URL %q: %w", s, err)
+		}
+		// /intl-ja/show/ID のようなロケール付きのパスもある
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) < 2 || parts[len(parts)-2] != "show" {
+			return "", fmt.Errorf("not a Spotify show URL: %q", s)
+		}
+		id = parts[len(parts)-1]
+	default:
+		id = ref
+	}
+
+	if !spotifyIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid Spotify show ID %q", id)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseShowRef(t *testing.T) {
+	const id = "4zqDMbg9WSpC5l81gJCfEc"
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"id", id, id, false},
+		{"id with spaces", "  " + id + " ", id, false},
+		{"uri", "spotify:show:" + id, id, false},
+		{"url", "https://open.spotify.com/show/" + id, id, false},
+		{"url with query", "https://open.spotify.com/show/" + id + "?si=abc", id, false},
+		{"url without scheme", "open.spotify.com/show/" + id, id, false},
+		{"localized url", "https://open.spotify.com/intl-ja/show/" + id, id, false},
+		{"episode uri", "spotify:episode:" + id, "", true},
+		{"episode url", "https://open.spotify.com/episode/" + id, "", true},
+		{"short id", "abc", "", true},
+		{"invalid characters", "4zqDMbg9WSpC5l81gJCf-c", "", true},
+		{"empty", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseShowRef(tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseShowRef(%q) = %q, want an error", tt.ref, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseShowRef(%q) = %q, %v, want %q", tt.ref, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// 前回同期時の番組の状態。条件付きGETと総エピソード数の比較に使う
+type ShowState struct {
+	ETag          string `json:"etag,omitempty"`
+	LastModified  string `json:"last_modified,omitempty"`
+	TotalEpisodes int    `json:"total_episodes"`
+	// 前回の絞り込み。違えば番組が変わっていなくても書き込む内容が変わる
+	Filters SyncFilters `json:"filters"`
+}
+
+// 書き込むエピソードを選んだ条件
+type SyncFilters struct {
+	From         string `json:"from,omitempty"`
+	To           string `json:"to,omitempty"`
+	Market       string `json:"market,omitempty"`
+	SkipExternal bool   `json:"skip_external,omitempty"`
+	SkipExplicit bool   `json:"skip_explicit,omitempty"`
+	PlayableOnly bool   `json:"playable_only,omitempty"`
+	SkipInvalid  bool   `json:"skip_invalid,omitempty"`
+}
+
+type ShowStates map[string]ShowState
+
+func LoadShowStates(path string) (ShowStates, error) {
+	states := ShowStates{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &states)
+	if err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+func SaveShowStates(path string, states ShowStates) error {
+	data, err := json.MarshalIndent(states, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
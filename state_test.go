@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchNotModifiedSkipsSync(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/shows/"+testShowA {
+			return false
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		w.Header().Set("ETag", `"v1"`)
+		return false
+	}
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	sink := registerCapturingSink(t, "capture")
+
+	code := runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("first run exit code = %d, want 0", code)
+	}
+	states, err := LoadShowStates(config.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states[testShowA].ETag != `"v1"` {
+		t.Fatalf("saved state = %+v, want the ETag", states[testShowA])
+	}
+
+	writes := dynamo.countCalls("BatchWriteItem")
+	pages := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes"))
+
+	code = runCommand(t, config, "fetch", "-show", testShowA, "-report", "capture")
+	if code != 0 {
+		t.Fatalf("second run exit code = %d, want 0", code)
+	}
+	if n := dynamo.countCalls("BatchWriteItem"); n != writes {
+		t.Errorf("second run wrote %d batches, want none", n-writes)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")); n != pages {
+		t.Errorf("second run fetched %d more pages, want none", n-pages)
+	}
+	if stats := sink.last(t); stats.Unchanged != 1 {
+		t.Errorf("Unchanged = %d, want 1", stats.Unchanged)
+	}
+}
+
+func TestFetchStateFallbackChecksFilters(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	// ETagもLast-Modifiedも返さないので総エピソード数で判定する
+	spotify.addShow(testShowA, 5)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	code := runCommand(t, config, "fetch", "-show", testShowA, "-from", "2024-01-04")
+	if code != 0 {
+		t.Fatalf("first run exit code = %d, want 0", code)
+	}
+	if n := len(dynamo.ids(config.tableName())); n != 2 {
+		t.Fatalf("first run stored %d episodes, want 2", n)
+	}
+
+	// 総数は同じでも範囲が広がったので書き込む
+	code = runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("second run exit code = %d, want 0", code)
+	}
+	if n := len(dynamo.ids(config.tableName())); n != 5 {
+		t.Errorf("second run stored %d episodes, want 5", n)
+	}
+
+	// 同じ絞り込みなら省く
+	writes := dynamo.countCalls("BatchWriteItem")
+	code = runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("third run exit code = %d, want 0", code)
+	}
+	if n := dynamo.countCalls("BatchWriteItem"); n != writes {
+		t.Errorf("third run wrote %d batches, want none", n-writes)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+type Stats struct {
+	Episodes         int
+	TotalDuration    time.Duration
+	AverageDuration  time.Duration
+	MedianDuration   time.Duration
+	EpisodesPerMonth map[string]int
+	ExplicitRatio    float64
+}
+
+func ShowStats(items []Item) Stats {
+	stats := Stats{
+		Episodes:         len(items),
+		EpisodesPerMonth: make(map[string]int),
+	}
+	if len(items) == 0 {
+		return stats
+	}
+
+	var explicit int
+	durations := make([]time.Duration, 0, len(items))
+	for _, item := range items {
+		duration := time.Duration(item.DurationMs) * time.Millisecond
+		durations = append(durations, duration)
+		stats.TotalDuration += duration
+
+		if item.Explicit {
+			explicit++
+		}
+
+		// 年単位の公開日は月が分からないので集計しない
+		if item.ReleaseDatePrecision != "year" && len(item.ReleaseDate) >= len("2006-01") {
+			stats.EpisodesPerMonth[item.ReleaseDate[:len("2006-01")]]++
+		}
+	}
+
+	slices.Sort(durations)
+	n := len(durations)
+	if n%2 == 1 {
+		stats.MedianDuration = durations[n/2]
+	} else {
+		stats.MedianDuration = (durations[n/2-1] + durations[n/2]) / 2
+	}
+
+	stats.AverageDuration = stats.TotalDuration / time.Duration(n)
+	stats.ExplicitRatio = float64(explicit) / float64(n)
+
+	return stats
+}
+
+func (s Stats) Print(w io.Writer) {
+	fmt.Fprintf(w, "Episodes:         %d\n", s.Episodes)
+	fmt.Fprintf(w, "Total duration:   %s\n", s.TotalDuration)
+	fmt.Fprintf(w, "Average duration: %s\n", s.AverageDuration.Round(time.Second))
+	fmt.Fprintf(w, "Median duration:  %s\n", s.MedianDuration.Round(time.Second))
+	fmt.Fprintf(w, "Explicit ratio:   %.2f\n", s.ExplicitRatio)
+
+	months := make([]string, 0, len(s.EpisodesPerMonth))
+	for month := range s.EpisodesPerMonth {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+
+	fmt.Fprintln(w, "Episodes per month:")
+	for _, month := range months {
+		fmt.Fprintf(w, "  %s: %d\n", month, s.EpisodesPerMonth[month])
+	}
+}
+
+// -dry-run で書き込む予定のエピソードの概要を表示する
+func PrintDryRun(w io.Writer, items []Item) {
+	fmt.Fprintf(w, "Dry run: %d episodes would be written\n", len(items))
+	if len(items) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "First episode:  %s (%s)\n", items[0].Name, items[0].ReleaseDate)
+	fmt.Fprintf(w, "Last episode:   %s (%s)\n", items[len(items)-1].Name, items[len(items)-1].ReleaseDate)
+	fmt.Fprintf(w, "Total duration: %s\n", ShowStats(items).TotalDuration)
+}
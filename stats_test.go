@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShowStats(t *testing.T) {
+	episode := func(minutes int64, date, precision string, explicit bool) Item {
+		return Item{DurationMs: minutes * 60000, ReleaseDate: date, ReleaseDatePrecision: precision, Explicit: explicit}
+	}
+
+	tests := []struct {
+		name  string
+		items []Item
+		want  Stats
+	}{
+		{
+			name:  "empty",
+			items: nil,
+			want:  Stats{EpisodesPerMonth: map[string]int{}},
+		},
+		{
+			name: "odd count",
+			items: []Item{
+				episode(30, "2024-01-05", "day", false),
+				episode(10, "2024-01-20", "day", true),
+				episode(20, "2024-02-01", "day", false),
+			},
+			want: Stats{
+				Episodes:         3,
+				TotalDuration:    60 * time.Minute,
+				AverageDuration:  20 * time.Minute,
+				MedianDuration:   20 * time.Minute,
+				EpisodesPerMonth: map[string]int{"2024-01": 2, "2024-02": 1},
+				ExplicitRatio:    1.0 / 3,
+			},
+		},
+		{
+			name: "even count",
+			items: []Item{
+				episode(40, "2024-03", "month", true),
+				episode(10, "2024", "year", true),
+				episode(30, "2024-03-10", "day", false),
+				episode(20, "2024-04-01", "day", false),
+			},
+			want: Stats{
+				Episodes:         4,
+				TotalDuration:    100 * time.Minute,
+				AverageDuration:  25 * time.Minute,
+				MedianDuration:   25 * time.Minute,
+				EpisodesPerMonth: map[string]int{"2024-03": 2, "2024-04": 1},
+				ExplicitRatio:    0.5,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShowStats(tt.items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ShowStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchDryRunSkipsDynamoDB(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	var code int
+	out := captureStdout(t, func() { code = runCommand(t, config, "fetch", "-dry-run", "-show", testShowA) })
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	for _, want := range []string{
+		"Dry run: 3 episodes would be written",
+		"First episode:  Episode 3 (2024-01-03)",
+		"Last episode:   Episode 1 (2024-01-01)",
+		"Total duration: 6m0s",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if len(dynamo.calls) != 0 {
+		t.Errorf("DynamoDB calls in a dry run: %v", dynamo.calls)
+	}
+}
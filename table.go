@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const defaultTableName = "Program"
+
+// エピソードを書くテーブル。未設定なら従来どおり "Program"
+func (c Config) tableName() string {
+	if c.TableName == "" {
+		return defaultTableName
+	}
+	return c.TableName
+}
+
+// テーブル名ごとのロック。同じテーブルの作成を同時に行わない
+var tableLocks sync.Map
+
+// エピソード用のテーブルが無ければ作成して使えるようになるまで待つ
+func EnsureTable(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string) error {
+	return ensureTable(ctx, svc, episodeTableInput(tableName))
+}
+
+// エピソード用のテーブルの定義
+func episodeTableInput(tableName string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("ID"),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("ID"),
+				KeyType:       aws.String(dynamodb.KeyTypeHash),
+			},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	}
+}
+
+var ErrKeySchemaMismatch = errors.New("table key schema mismatch")
+
+// "ID (HASH)" のようにキーを並べる
+func keySchemaString(schema []*dynamodb.KeySchemaElement) string {
+	keys := make([]string, len(schema))
+	for i, element := range schema {
+		keys[i] = fmt.Sprintf("%s (%s)", aws.StringValue(element.AttributeName), aws.StringValue(element.KeyType))
+	}
+	return strings.Join(keys, ", ")
+}
+
+func ensureTable(ctx context.Context, svc dynamodbiface.DynamoDBAPI, input *dynamodb.CreateTableInput) error {
+	tableName := aws.StringValue(input.TableName)
+
+	lock, _ := tableLocks.LoadOrStore(tableName, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	output, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	// キーの違うテーブルへの書き込みは1件ずつ拒否されるので、先に分かりやすいエラーにする
+	if err == nil {
+		if output.Table != nil && keySchemaString(output.Table.KeySchema) != keySchemaString(input.KeySchema) {
+			return fmt.Errorf("%w: table %s has key %s, want %s; recreate it with fetch -rebuild",
+				ErrKeySchemaMismatch, tableName, keySchemaString(output.Table.KeySchema), keySchemaString(input.KeySchema))
+		}
+		return nil
+	}
+
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeResourceNotFoundException {
+		return err
+	}
+
+	_, err = svc.CreateTableWithContext(ctx, input)
+	// 別プロセスが先に作成していた場合は成功とみなす
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceInUseException {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return svc.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+}
+
+func DeleteTable(ctx context.Context, svc dynamodbiface.DynamoDBAPI, tableName string) error {
+	_, err := svc.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{
+		TableName: aws.String(tableName),
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return svc.WaitUntilTableNotExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+}
+
+// -rebuildは既存データを消すので、すべての番組をそろえて取得できた場合に限る
+func checkRebuild(incomplete bool, skippedShows []string, items int, allowEmpty bool) error {
+	if incomplete {
+		return errors.New("refusing to -rebuild: some shows were not fetched completely")
+	}
+	if len(skippedShows) > 0 {
+		return fmt.Errorf("refusing to -rebuild: shows were skipped: %s", strings.Join(skippedShows, ", "))
+	}
+	if items == 0 && !allowEmpty {
+		return errors.New("refusing to -rebuild with no episodes (use -allow-empty to empty the tables)")
+	}
+	return nil
+}
+
+// 書き込み先のテーブルを削除して作り直す(-rebuild)
+func RebuildTables(ctx context.Context, config Config, items []Item) error {
+	svc, err := newDynamoClient(config)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{config.tableName()}
+	seen := map[string]bool{config.tableName(): true}
+	for _, item := range items {
+		for _, tableName := range tablesForItem(config, item) {
+			if !seen[tableName] {
+				seen[tableName] = true
+				tables = append(tables, tableName)
+			}
+		}
+	}
+
+	for _, tableName := range tables {
+		err = DeleteTable(ctx, svc, tableName)
+		if err != nil {
+			return err
+		}
+
+		err = EnsureTable(ctx, svc, tableName)
+		if err != nil {
+			return err
+		}
+		slog.Info("table recreated", "table", tableName)
+	}
+
+	return nil
+}
+
+// エピソードの書き込み先テーブル。言語別の場合は言語ごとのテーブルすべてに書く
+func tablesForItem(config Config, item Item) []string {
+	if !config.SplitByLanguage {
+		return []string{config.tableName()}
+	}
+
+	languages := item.Languages
+	if len(languages) == 0 && item.Language != "" {
+		languages = []string{item.Language}
+	}
+	if len(languages) == 0 {
+		return []string{config.tableName()}
+	}
+
+	var tables []string
+	for _, language := range languages {
+		tables = append(tables, config.tableName()+"_"+language)
+	}
+
+	return tables
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestCheckRebuild(t *testing.T) {
+	tests := []struct {
+		name         string
+		incomplete   bool
+		skippedShows []string
+		items        int
+		allowEmpty   bool
+		wantErr      bool
+	}{
+		{name: "all shows written", items: 3},
+		{name: "fetch failed", incomplete: true, items: 3, wantErr: true},
+		{name: "show skipped", skippedShows: []string{"not-a-show"}, items: 3, wantErr: true},
+		{name: "no episodes", wantErr: true},
+		{name: "no episodes with -allow-empty", allowEmpty: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRebuild(tt.incomplete, tt.skippedShows, tt.items, tt.allowEmpty)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRebuild() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTablesForItem(t *testing.T) {
+	config := Config{SplitByLanguage: true}
+
+	tests := []struct {
+		name string
+		item Item
+		want []string
+	}{
+		{"languages", Item{Languages: []string{"en", "ja"}}, []string{"Program_en", "Program_ja"}},
+		{"language", Item{Language: "ja"}, []string{"Program_ja"}},
+		{"no language", Item{}, []string{"Program"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tablesForItem(config, tt.item); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tablesForItem() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := tablesForItem(Config{}, Item{Languages: []string{"en"}}); !reflect.DeepEqual(got, []string{"Program"}) {
+		t.Errorf("tablesForItem() without split_by_language = %v, want [Program]", got)
+	}
+}
+
+func TestFetchSplitByLanguage(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	show := spotify.addShow(testShowA, 3)
+	show.Episodes[0].Languages = []string{"en"}
+	show.Episodes[1].Languages = []string{"ja"}
+	show.Episodes[2].Languages = []string{"en", "ja"}
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.SplitByLanguage = true
+
+	code := runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	for table, want := range map[string][]string{
+		"Program_en": {"1111-001", "1111-003"},
+		"Program_ja": {"1111-001", "1111-002"},
+	} {
+		got := dynamo.ids(table)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s has %v, want %v", table, got, want)
+		}
+	}
+}
+
+func TestEnsureTableConcurrent(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = EnsureTable(context.Background(), dynamo, "Concurrent")
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("EnsureTable() = %v, want nil", err)
+		}
+	}
+	if n := dynamo.countCalls("CreateTable"); n != 1 {
+		t.Errorf("CreateTable called %d times, want 1", n)
+	}
+}
+
+// 別のプロセスが先に作成していた場合も成功とみなす
+func TestEnsureTableCreatedElsewhere(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	dynamo.fail = func(op string, input any) error {
+		if op == "DescribeTable" {
+			return awserr.New(dynamodb.ErrCodeResourceNotFoundException, "not found", nil)
+		}
+		if op == "CreateTable" {
+			return awserr.New(dynamodb.ErrCodeResourceInUseException, "already exists", nil)
+		}
+		return nil
+	}
+
+	err := EnsureTable(context.Background(), dynamo, "Raced")
+	if err != nil {
+		t.Errorf("EnsureTable() = %v, want nil", err)
+	}
+}
+
+func TestEnsureTableKeySchemaMismatch(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 2)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	// 名前をキーにしていた頃のテーブル
+	_, err := dynamo.CreateTableWithContext(context.Background(), &dynamodb.CreateTableInput{
+		TableName: aws.String(config.tableName()),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("Name"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = EnsureTable(context.Background(), dynamo, config.tableName())
+	if !errors.Is(err, ErrKeySchemaMismatch) || !strings.Contains(err.Error(), "-rebuild") {
+		t.Errorf("EnsureTable() = %v, want ErrKeySchemaMismatch suggesting -rebuild", err)
+	}
+
+	if code := runCommand(t, config, "fetch", "-show", testShowA); code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if n := dynamo.countCalls("BatchWriteItem"); n != 0 {
+		t.Errorf("BatchWriteItem called %d times, want 0", n)
+	}
+
+	if code := runCommand(t, config, "fetch", "-rebuild", "-show", testShowA); code != 0 {
+		t.Fatalf("exit code with -rebuild = %d, want 0", code)
+	}
+	if n := len(dynamo.ids(config.tableName())); n != 2 {
+		t.Errorf("stored %d episodes after -rebuild, want 2", n)
+	}
+}
+
+func TestCustomTableName(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	// 各操作が対象にしたテーブル
+	tables := make(map[string][]string)
+	dynamo.fail = func(op string, input any) error {
+		switch input := input.(type) {
+		case *dynamodb.CreateTableInput:
+			tables[op] = append(tables[op], aws.StringValue(input.TableName))
+		case *dynamodb.DeleteTableInput:
+			tables[op] = append(tables[op], aws.StringValue(input.TableName))
+		case *dynamodb.PutItemInput:
+			tables[op] = append(tables[op], aws.StringValue(input.TableName))
+		case *dynamodb.BatchWriteItemInput:
+			for tableName := range input.RequestItems {
+				tables[op] = append(tables[op], tableName)
+			}
+		}
+		return nil
+	}
+	config := testConfig(spotify, dynamo.serve(t))
+	config.TableName = "DevPrograms"
+	config.ShowTableName = "DevShows"
+
+	for _, args := range [][]string{
+		{"create-table"},
+		{"fetch", "-show", testShowA},
+		{"delete-table"},
+	} {
+		if code := runCommand(t, config, args...); code != 0 {
+			t.Fatalf("%s exit code = %d, want 0", args[0], code)
+		}
+	}
+	// 1件ずつ書く経路
+	config.NoClobber = true
+	if code := runCommand(t, config, "fetch", "-show", testShowA); code != 0 {
+		t.Fatalf("fetch exit code = %d, want 0", code)
+	}
+
+	for _, op := range []string{"CreateTable", "BatchWriteItem", "PutItem", "DeleteTable"} {
+		if len(tables[op]) == 0 {
+			t.Errorf("no %s call", op)
+		}
+		for _, tableName := range tables[op] {
+			// 番組情報は別のテーブルに書く
+			if tableName != "DevPrograms" && tableName != "DevShows" {
+				t.Errorf("%s targeted table %s, want DevPrograms or DevShows", op, tableName)
+			}
+		}
+	}
+	if dynamo.hasTable("Program") {
+		t.Error("default table Program was created")
+	}
+}
+
+// DynamoDBに接続せず、インターフェースを満たすモックで作成・書き込み・削除を行う
+func TestTableOperationsWithMock(t *testing.T) {
+	dynamo := newFakeDynamoDB()
+	ctx := context.Background()
+
+	err := EnsureTable(ctx, dynamo, "Program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeItems(ctx, dynamo, Config{}, testItems(3), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dynamo.ids("Program")); n != 3 {
+		t.Errorf("%d items written, want 3", n)
+	}
+	err = DeleteTable(ctx, dynamo, "Program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 無いテーブルの削除は成功とみなす
+	err = DeleteTable(ctx, dynamo, "Program")
+	if err != nil {
+		t.Errorf("DeleteTable() of a missing table = %v, want nil", err)
+	}
+
+	want := []string{"DescribeTable", "CreateTable", "DescribeTable", "BatchWriteItem", "DeleteTable", "DeleteTable"}
+	if !reflect.DeepEqual(dynamo.calls, want) {
+		t.Errorf("calls = %v, want %v", dynamo.calls, want)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// 期限切れの60秒前からトークンを取り直す
+const tokenRefreshMargin = 60 * time.Second
+
+type TokenManager struct {
+	client *http.Client
+	config Config
+	token  TokenResponse
+	expiry time.Time
+
+	mu sync.Mutex
+}
+
+func NewTokenManager(client *http.Client, config Config) *TokenManager {
+	return &TokenManager{client: client, config: config}
+}
+
+// 有効なアクセストークンを返す。未取得か期限が近ければ取得し直す
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token.AccessToken != "" && time.Now().Add(tokenRefreshMargin).Before(m.expiry) {
+		return m.token.AccessToken, nil
+	}
+
+	// 前回の実行で取得したトークンがまだ使えればそれを使う
+	path := m.config.tokenCachePath()
+	if m.token.AccessToken == "" && path != "" {
+		token, expiry, ok := loadCachedToken(path, m.config)
+		if ok && time.Now().Add(tokenRefreshMargin).Before(expiry) {
+			m.token = token
+			m.expiry = expiry
+			slog.Debug("token loaded from cache", "path", path, "expires_at", expiry.Format(time.RFC3339))
+			return m.token.AccessToken, nil
+		}
+	}
+
+	ctx, span := tracer.Start(ctx, "auth")
+	defer span.End()
+
+	token, err := GetAccessToken(ctx, m.client, m.config)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	m.token = token
+	m.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	slog.Debug("token acquired", "expires_at", m.expiry.Format(time.RFC3339))
+
+	if path != "" {
+		err = saveCachedToken(path, m.config, m.token, m.expiry)
+		if err != nil {
+			slog.Error("failed to cache access token", "error", err)
+		}
+	}
+
+	return m.token.AccessToken, nil
+}
+
+// APIに拒否されたトークンを捨て、キャッシュファイルも消す。
+// 並行して取り直した新しいトークンは捨てない
+func (m *TokenManager) Invalidate(accessToken string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token.AccessToken != "" && m.token.AccessToken != accessToken {
+		return
+	}
+	m.token = TokenResponse{}
+	m.expiry = time.Time{}
+
+	path := m.config.tokenCachePath()
+	if path == "" {
+		return
+	}
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("failed to remove cached access token", "path", path, "error", err)
+	}
+}
+
+// ディスクに保存するトークン。別のクライアントのトークンを使わないようにIDとURLも残す
+type cachedToken struct {
+	ClientID    string    `json:"client_id"`
+	TokenURL    string    `json:"token_url"`
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// トークンのキャッシュ先。未設定なら ~/.podcast/token.json、"-" なら保存しない
+func (c Config) tokenCachePath() string {
+	switch c.TokenCacheFile {
+	case "-":
+		return ""
+	case "":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(home, ".podcast", "token.json")
+	default:
+		return c.TokenCacheFile
+	}
+}
+
+func loadCachedToken(path string, config Config) (TokenResponse, time.Time, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TokenResponse{}, time.Time{}, false
+	}
+
+	var cached cachedToken
+	err = json.Unmarshal(data, &cached)
+	if err != nil || cached.ClientID != config.ClientID || cached.TokenURL != config.TokenURL || cached.AccessToken == "" {
+		return TokenResponse{}, time.Time{}, false
+	}
+
+	token := TokenResponse{
+		AccessToken: cached.AccessToken,
+		TokenType:   cached.TokenType,
+		ExpiresIn:   int(time.Until(cached.ExpiresAt).Seconds()),
+	}
+	return token, cached.ExpiresAt, true
+}
+
+// ベアラートークンなので本人だけが読めるようにする
+func saveCachedToken(path string, config Config, token TokenResponse, expiry time.Time) error {
+	data, err := json.Marshal(cachedToken{
+		ClientID:    config.ClientID,
+		TokenURL:    config.TokenURL,
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		ExpiresAt:   expiry.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// 途中で止まっても壊れたファイルを残さない
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	// 既存のファイルがあってもパーミッションを揃える
+	err = os.Chmod(tmp, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenRefreshNearExpiry(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	config := testConfig(spotify, "")
+	manager := testTokenManager(spotify, config)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		token, err := manager.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("Token() = %q, want token-1", token)
+		}
+	}
+	if n := spotify.tokenCount(); n != 1 {
+		t.Errorf("%d token requests for a valid token, want 1", n)
+	}
+
+	// 期限まで60秒を切ったら取得し直す
+	manager.expiry = time.Now().Add(30 * time.Second)
+	token, err := manager.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-2" || spotify.tokenCount() != 2 {
+		t.Errorf("Token() = %q after %d token requests, want a refreshed token-2", token, spotify.tokenCount())
+	}
+}
+
+func TestFetchRefreshesTokenBetweenPages(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 6)
+	// 取得した時点で期限が近いトークン
+	spotify.expiresIn = 30
+	config := testConfig(spotify, "")
+	config.PageSize = 2
+
+	result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 6 {
+		t.Errorf("%d items, want 6", len(result.Items))
+	}
+
+	var got []string
+	for _, r := range spotify.requestsTo("/v1/shows/" + testShowA + "/episodes") {
+		got = append(got, r.Header.Get("Authorization"))
+	}
+	if len(got) != 2 || got[0] == got[1] {
+		t.Errorf("episode page tokens = %q, want a new token for each page", got)
+	}
+}
+
+func TestCachedTokenSkipsRequest(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	config := testConfig(spotify, "")
+	config.TokenCacheFile = filepath.Join(t.TempDir(), "podcast", "token.json")
+
+	err := saveCachedToken(config.TokenCacheFile, config, TokenResponse{AccessToken: "cached", TokenType: "Bearer"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(config.TokenCacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %04o, want 0600", info.Mode().Perm())
+	}
+
+	token, err := testTokenManager(spotify, config).Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "cached" {
+		t.Errorf("Token() = %q, want the cached token", token)
+	}
+	if n := len(spotify.requests); n != 0 {
+		t.Errorf("%d HTTP requests with a valid cached token, want none", n)
+	}
+
+	// 期限が近ければ取得し直して保存する
+	err = saveCachedToken(config.TokenCacheFile, config, TokenResponse{AccessToken: "cached", TokenType: "Bearer"}, time.Now().Add(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = testTokenManager(spotify, config).Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" {
+		t.Errorf("Token() = %q, want a new token", token)
+	}
+	cached, _, ok := loadCachedToken(config.TokenCacheFile, config)
+	if !ok || cached.AccessToken != "token-1" {
+		t.Errorf("cached token = %q, want token-1", cached.AccessToken)
+	}
+
+	// 別のクライアントのトークンは使わない
+	other := config
+	other.ClientID = "other"
+	if _, _, ok := loadCachedToken(config.TokenCacheFile, other); ok {
+		t.Error("token cached for another client was loaded")
+	}
+}
+
+func TestRejectedTokenIsReplaced(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 2)
+	// キャッシュ上は期限内だが、APIでは取り消されている
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") == "Bearer revoked" {
+			http.Error(w, `{"error":{"status":401,"message":"The access token expired"}}`, http.StatusUnauthorized)
+			return true
+		}
+		return false
+	}
+	config := testConfig(spotify, "")
+	config.TokenCacheFile = filepath.Join(t.TempDir(), "token.json")
+	err := saveCachedToken(config.TokenCacheFile, config, TokenResponse{AccessToken: "revoked", TokenType: "Bearer"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 2 {
+		t.Errorf("%d items, want 2", len(result.Items))
+	}
+	if n := spotify.tokenCount(); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+	cached, _, ok := loadCachedToken(config.TokenCacheFile, config)
+	if !ok || cached.AccessToken != "token-1" {
+		t.Errorf("cached token = %q, want the new token-1", cached.AccessToken)
+	}
+
+	// 取り直したトークンも拒否されたら諦める
+	spotify.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/token" {
+			http.Error(w, `{"error":{"status":401,"message":"Invalid access token"}}`, http.StatusUnauthorized)
+			return true
+		}
+		return false
+	}
+	before := len(spotify.requestsTo("/v1/shows/" + testShowA))
+	_, err = FetchItems(context.Background(), config, testTokenManager(spotify, config), testShowA, FetchOptions{})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("FetchItems() error = %v, want the 401 status", err)
+	}
+	if n := len(spotify.requestsTo("/v1/shows/"+testShowA)) - before; n != 2 {
+		t.Errorf("%d show requests, want one retry", n)
+	}
+}
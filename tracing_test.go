@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestFetchSpans(t *testing.T) {
+	spans := recordSpans(t)
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 3)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	code := runCommand(t, config, "fetch", "-show", testShowA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	runs := spansNamed(spans, "run")
+	if len(runs) != 1 {
+		t.Fatalf("%d run spans, want 1", len(runs))
+	}
+	root := runs[0].SpanContext.TraceID()
+
+	for _, name := range []string{"auth", "fetch page", "write", "write batch"} {
+		named := spansNamed(spans, name)
+		if len(named) == 0 {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		for _, span := range named {
+			if span.SpanContext.TraceID() != root {
+				t.Errorf("%s span is not part of the run trace", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "fmt"
+
+type ItemProblem struct {
+	Item    Item
+	Missing []string
+}
+
+func (p ItemProblem) String() string {
+	return fmt.Sprintf("episode %q (id %q) is missing %v", p.Item.Name, p.Item.ID, p.Missing)
+}
+
+// Name、ID、ReleaseDateが欠けているエピソードを探す
+func FindInvalidItems(items []Item) []ItemProblem {
+	var problems []ItemProblem
+	for _, item := range items {
+		var missing []string
+		if item.Name == "" {
+			missing = append(missing, "Name")
+		}
+		if item.ID == "" {
+			missing = append(missing, "ID")
+		}
+		if item.ReleaseDate == "" {
+			missing = append(missing, "ReleaseDate")
+		}
+
+		if len(missing) > 0 {
+			problems = append(problems, ItemProblem{Item: item, Missing: missing})
+		}
+	}
+
+	return problems
+}
+
+func SkipInvalidItems(items []Item) []Item {
+	var kept []Item
+	for _, item := range items {
+		if item.Name != "" && item.ID != "" && item.ReleaseDate != "" {
+			kept = append(kept, item)
+		}
+	}
+
+	return kept
+}
+
+// すべてのエピソードがプレビュー無しかつ再生不可なら、マーケットやスコープの設定ミスが疑われる
+func LooksUnavailable(items []Item) bool {
+	if len(items) == 0 {
+		return false
+	}
+
+	for _, item := range items {
+		if item.AudioPreviewURL != "" || item.IsPlayable {
+			return false
+		}
+	}
+
+	return true
+}
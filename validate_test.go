@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFindInvalidItems(t *testing.T) {
+	items := testItems(3)
+	for i := range items {
+		items[i].ReleaseDate = "2024-01-01"
+	}
+	items[1].ID = ""
+
+	problems := FindInvalidItems(items)
+	if len(problems) != 1 {
+		t.Fatalf("%d problems, want 1: %v", len(problems), problems)
+	}
+	if problems[0].Item.Name != "Episode 2" || !reflect.DeepEqual(problems[0].Missing, []string{"ID"}) {
+		t.Errorf("problem = %+v, want Episode 2 missing ID", problems[0])
+	}
+
+	kept := SkipInvalidItems(items)
+	if got, want := itemIDs(kept), []string{"ep001", "ep003"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept = %v, want %v", got, want)
+	}
+}
+
+func TestFetchSkipInvalid(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	show := spotify.addShow(testShowA, 3)
+	show.Episodes[1].ID = ""
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	if code := runCommand(t, config, "fetch", "-skip-invalid", "-show", testShowA); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	got := dynamo.ids(config.tableName())
+	sort.Strings(got)
+	if want := []string{"1111-001", "1111-003"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored IDs = %v, want %v", got, want)
+	}
+}
+
+func TestFetchWarnsWhenShowLooksUnavailable(t *testing.T) {
+	const warning = "no episode is playable or has an audio preview; the token may lack access to this content or the market may be misconfigured"
+
+	logs := captureLogs(t)
+	spotify := newFakeSpotify(t)
+	show := spotify.addShow(testShowA, 3)
+	for i := range show.Episodes {
+		show.Episodes[i].IsPlayable = false
+	}
+	spotify.addShow(testShowB, 3)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	if code := runCommand(t, config, "fetch", "-log-format", "json", "-show", testShowA+","+testShowB); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var shows []any
+	for _, record := range slogRecords(t, logs.String()) {
+		if record["level"] == "WARN" && record["msg"] == warning {
+			shows = append(shows, record["show"])
+		}
+	}
+	if want := []any{testShowA}; !reflect.DeepEqual(shows, want) {
+		t.Errorf("warned for shows %v, want %v", shows, want)
+	}
+	if LooksUnavailable(nil) {
+		t.Error("LooksUnavailable(nil) = true, want false")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// 公開日の範囲指定。ゼロ値は無制限
+type DateWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+func ParseDateWindow(from, to string) (DateWindow, error) {
+	var window DateWindow
+	var err error
+
+	if from != "" {
+		window.From, err = time.Parse(time.DateOnly, from)
+		if err != nil {
+			return window, fmt.Errorf("invalid -from date %q: %w", from, err)
+		}
+	}
+	if to != "" {
+		window.To, err = time.Parse(time.DateOnly, to)
+		if err != nil {
+			return window, fmt.Errorf("invalid -to date %q: %w", to, err)
+		}
+		// -to はその日を含む
+		window.To = window.To.AddDate(0, 0, 1)
+	}
+	if !window.From.IsZero() && !window.To.IsZero() && !window.From.Before(window.To) {
+		return window, fmt.Errorf("-from %s is after -to %s", from, to)
+	}
+
+	return window, nil
+}
+
+// 公開日が取りうる期間 [start, end) を返す
+func releaseDateRange(item Item) (time.Time, time.Time, error) {
+	switch item.ReleaseDatePrecision {
+	case "year":
+		start, err := time.Parse("2006", item.ReleaseDate)
+		return start, start.AddDate(1, 0, 0), err
+	case "month":
+		start, err := time.Parse("2006-01", item.ReleaseDate)
+		return start, start.AddDate(0, 1, 0), err
+	default:
+		start, err := time.Parse(time.DateOnly, item.ReleaseDate)
+		return start, start.AddDate(0, 0, 1), err
+	}
+}
+
+func (w DateWindow) IsZero() bool {
+	return w.From.IsZero() && w.To.IsZero()
+}
+
+// 公開日の期間が範囲と少しでも重なれば含める。日付が読めないものも残す
+func (w DateWindow) Contains(item Item) bool {
+	start, end, err := releaseDateRange(item)
+	if err != nil {
+		return true
+	}
+	if !w.From.IsZero() && !end.After(w.From) {
+		return false
+	}
+	if !w.To.IsZero() && !start.Before(w.To) {
+		return false
+	}
+
+	return true
+}
+
+// 公開日の期間がすべて -from より前ならtrue。新しい順に並ぶので以降のページは不要
+func (w DateWindow) IsOlder(item Item) bool {
+	if w.From.IsZero() {
+		return false
+	}
+	_, end, err := releaseDateRange(item)
+	if err != nil {
+		return false
+	}
+
+	return !end.After(w.From)
+}
+
+func (w DateWindow) Filter(items []Item) []Item {
+	var kept []Item
+	for _, item := range items {
+		if w.Contains(item) {
+			kept = append(kept, item)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 新しい順に並ぶので、-fromより古いエピソードのページに届いたら以降は取得しない
+func TestFetchStopsPagingAtFrom(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	spotify.addShow(testShowA, 10)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+	config.PageSize = 2
+
+	code := runCommand(t, config, "fetch", "-show", testShowA, "-from", "2024-01-08")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	// 1ページ目(10, 9)と2ページ目(8, 7)で止まる
+	if n := len(spotify.requestsTo("/v1/shows/" + testShowA + "/episodes")); n != 1 {
+		t.Errorf("fetched %d more pages, want 1", n)
+	}
+	got := dynamo.ids(config.tableName())
+	sort.Strings(got)
+	if want := []string{"1111-008", "1111-009", "1111-010"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored IDs = %v, want %v", got, want)
+	}
+}
+
+func TestDateWindowFilter(t *testing.T) {
+	window, err := ParseDateWindow("2024-01-02", "2024-01-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []Item{
+		{ID: "before", ReleaseDate: "2024-01-01"},
+		{ID: "first", ReleaseDate: "2024-01-02"},
+		{ID: "last", ReleaseDate: "2024-01-03"},
+		{ID: "after", ReleaseDate: "2024-01-04"},
+	}
+
+	var got []string
+	for _, item := range window.Filter(items) {
+		got = append(got, item.ID)
+	}
+	if want := []string{"first", "last"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+}
+
+func TestFetchSinceMixedPrecision(t *testing.T) {
+	spotify := newFakeSpotify(t)
+	show := spotify.addShow(testShowA, 0)
+	show.Episodes = []Item{
+		{ID: "day-after", Name: "Day after", ReleaseDate: "2024-03-02", ReleaseDatePrecision: "day", IsPlayable: true},
+		{ID: "day-on", Name: "Day on", ReleaseDate: "2024-03-01", ReleaseDatePrecision: "day", IsPlayable: true},
+		{ID: "month-on", Name: "Month on", ReleaseDate: "2024-03", ReleaseDatePrecision: "month", IsPlayable: true},
+		{ID: "day-before", Name: "Day before", ReleaseDate: "2024-02-29", ReleaseDatePrecision: "day", IsPlayable: true},
+		{ID: "month-before", Name: "Month before", ReleaseDate: "2024-02", ReleaseDatePrecision: "month", IsPlayable: true},
+		{ID: "year-on", Name: "Year on", ReleaseDate: "2024", ReleaseDatePrecision: "year", IsPlayable: true},
+		{ID: "year-before", Name: "Year before", ReleaseDate: "2023", ReleaseDatePrecision: "year", IsPlayable: true},
+	}
+	spotify.firstPage = len(show.Episodes)
+	dynamo := newFakeDynamoDB()
+	config := testConfig(spotify, dynamo.serve(t))
+
+	code := runCommand(t, config, "fetch", "-show", testShowA, "-since", "2024-03-01")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	// 年・月単位の公開日は、その年・月が境界の日を含めば残す
+	got := dynamo.ids(config.tableName())
+	sort.Strings(got)
+	if want := []string{"day-after", "day-on", "month-on", "year-on"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored IDs = %v, want %v", got, want)
+	}
+
+	if _, err := ParseDateWindow("2024/03/01", ""); err == nil || !strings.Contains(err.Error(), "2024/03/01") {
+		t.Errorf("ParseDateWindow() = %v, want an error naming the invalid date", err)
+	}
+	if code := runCommand(t, config, "fetch", "-show", testShowA, "-since", "March 1"); code == 0 {
+		t.Error("invalid -since date accepted")
+	}
+}